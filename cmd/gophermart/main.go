@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/alexkopcak/gophermart/internal/app"
 	"github.com/alexkopcak/gophermart/internal/config"
 	"github.com/gin-gonic/gin"
@@ -25,9 +27,18 @@ func main() {
 
 	cfg := config.Init()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg.DataBaseURI, os.Args[2:]); err != nil {
+			logger.Fatal().Err(err).Msg("migrate error")
+		}
+		return
+	}
+
 	logger.Debug().Str("run address", cfg.RunAddress).Msg("get config")
 
 	app := app.NewApp(cfg)
 
-	logger.Fatal().Err(app.Run())
+	if err := app.Run(); err != nil {
+		logger.Fatal().Err(err).Msg("run error")
+	}
 }
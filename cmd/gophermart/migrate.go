@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/alexkopcak/gophermart/internal/migrations"
+)
+
+const migrateUsage = "usage: gophermart migrate up|down|status|to N"
+
+var errMigrateUsage = errors.New(migrateUsage)
+
+// runMigrate handles `gophermart migrate ...`, down rolls back a single migration.
+func runMigrate(dbURI string, args []string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	switch args[0] {
+	case "up":
+		return migrations.Up(dbURI)
+	case "down":
+		return migrations.Down(dbURI)
+	case "to":
+		if len(args) != 2 {
+			return errMigrateUsage
+		}
+		version, err := strconv.ParseUint(args[1], 10, 0)
+		if err != nil {
+			return errMigrateUsage
+		}
+		return migrations.To(dbURI, uint(version))
+	case "status":
+		status, err := migrations.GetStatus(dbURI)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version: %d\nlatest: %d\ndirty: %t\n", status.Version, status.Latest, status.Dirty)
+		return nil
+	}
+	return errMigrateUsage
+}
@@ -1,28 +1,53 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
+	authhandlers "github.com/alexkopcak/gophermart/internal/auth/handlers"
+	"github.com/alexkopcak/gophermart/internal/auth/keys"
+	"github.com/alexkopcak/gophermart/internal/auth/password"
 	authdb "github.com/alexkopcak/gophermart/internal/auth/repository/postgres"
+	"github.com/alexkopcak/gophermart/internal/auth/throttle"
 	authusecase "github.com/alexkopcak/gophermart/internal/auth/usecase"
+	"github.com/alexkopcak/gophermart/internal/database"
+	"github.com/alexkopcak/gophermart/internal/idempotency"
+	idempotencydb "github.com/alexkopcak/gophermart/internal/idempotency/repository/postgres"
+	"github.com/alexkopcak/gophermart/internal/migrations"
 	"github.com/alexkopcak/gophermart/internal/order"
+	"github.com/alexkopcak/gophermart/internal/order/integration"
 
 	orderdb "github.com/alexkopcak/gophermart/internal/order/repository/postgres"
 	orderusecase "github.com/alexkopcak/gophermart/internal/order/usecase"
-	"github.com/gin-gonic/gin"
 
 	"github.com/alexkopcak/gophermart/internal/config"
 	"github.com/alexkopcak/gophermart/internal/httpserver"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog/log"
 )
 
 type App struct {
 	config *config.Config
-	server *gin.Engine
+	server *http.Server
 
-	authUC  auth.UseCase
-	orderUC order.UseCase
+	authUC         auth.UseCase
+	orderUC        order.UseCase
+	loginThrottler *throttle.Throttler
+
+	accrualQueue    order.AccrualQueue
+	idempotencyRepo idempotency.Repository
+
+	pool         *pgxpool.Pool
+	tokenSources []authhandlers.TokenSource
+
+	latency cachedStats
 }
 
 func NewApp(cfg *config.Config) *App {
@@ -30,37 +55,275 @@ func NewApp(cfg *config.Config) *App {
 	logger.Debug().Msg("enter")
 	defer logger.Debug().Msg("exit")
 
-	//userRepo := authlocalstorage.NewUserLocalStorage()
-	userRepo := authdb.NewPostgresStorage(cfg.DataBaseURI)
+	if cfg.MigrateOnStart {
+		if err := migrations.Up(cfg.DataBaseURI); err != nil {
+			logger.Fatal().Err(err).Msg("migrate database error")
+		}
+	}
+
+	pool, err := database.NewPool(context.Background(), database.PoolConfig{
+		DataBaseURI:       cfg.DataBaseURI,
+		MinConns:          int32(cfg.DBMinConns),
+		MaxConns:          int32(cfg.DBMaxConns),
+		MaxConnLifetime:   time.Duration(cfg.DBMaxConnLifetime) * time.Second,
+		MaxConnIdleTime:   time.Duration(cfg.DBMaxConnIdleTime) * time.Second,
+		HealthCheckPeriod: time.Duration(cfg.DBHealthCheckPeriod) * time.Second,
+		StatementTimeout:  time.Duration(cfg.DBStatementTimeout) * time.Second,
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("connect to database error")
+	}
+
+	//ledgerRepo := ledgerlocalstorage.NewLedgerLocalStorage()
+	//userRepo := authlocalstorage.NewUserLocalStorageWithLedger(ledgerRepo)
+	userRepo := authdb.NewPostgresStorage(pool)
 
-	//orderRepo := orderlocalstorage.NewOrderLocalStorage()
-	orderRepo := orderdb.NewOrderPostgresStorage(cfg.DataBaseURI)
+	//sessionRepo := authlocalstorage.NewSessionLocalStorage()
+	sessionRepo := authdb.NewSessionPostgresStorage(pool)
+
+	//orderRepo := orderlocalstorage.NewOrderLocalStorageWithLedger(ledgerRepo)
+	orderRepo := orderdb.NewOrderPostgresStorage(pool)
+
+	//accrualQueue := orderlocalstorage.NewAccrualQueueLocalStorage()
+	accrualQueue := orderdb.NewAccrualQueuePostgresStorage(pool)
+
+	//loginThrottleRepo := authlocalstorage.NewLoginThrottleLocalStorage()
+	loginThrottleRepo := authdb.NewLoginThrottlePostgresStorage(pool)
+
+	//idempotencyRepo := idempotencylocalstorage.NewIdempotencyLocalStorage()
+	idempotencyRepo := idempotencydb.NewIdempotencyPostgresStorage(pool)
+
+	hasher, err := password.NewHasher(password.Config{
+		Algorithm: cfg.PasswordHashAlgorithm,
+		Argon2: password.Argon2Params{
+			Memory:      uint32(cfg.Argon2Memory),
+			Iterations:  uint32(cfg.Argon2Iterations),
+			Parallelism: uint8(cfg.Argon2Parallelism),
+		},
+		BcryptCost: cfg.BcryptCost,
+		LegacySalt: cfg.HashSalt,
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("create password hasher error")
+	}
+
+	// the tokens of the previous claims version are accepted until the time
+	// in RFC 3339, set it to the upgrade time plus TOKEN_TTL
+	var legacyTokensUntil time.Time
+	if cfg.LegacyTokensUntil != "" {
+		legacyTokensUntil, err = time.Parse(time.RFC3339, cfg.LegacyTokensUntil)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("parse legacy tokens time error")
+		}
+	}
+
+	keySet := keys.NewHMACKeySet([]byte(cfg.SigningKey))
+	if cfg.JWTKeysDir != "" {
+		// the tokens signed with SIGNING_KEY before the switch are verified
+		// until they expire, the legacy ones until their window closes
+		hmacUntil := time.Now().Add(time.Duration(cfg.TokenTTL) * time.Second)
+		if legacyTokensUntil.After(hmacUntil) {
+			hmacUntil = legacyTokensUntil
+		}
+		keySet, err = keys.LoadKeySet(cfg.JWTKeysDir, cfg.JWTSigningKeyID,
+			keys.NewHMACVerificationKey([]byte(cfg.SigningKey), hmacUntil))
+		if err != nil {
+			logger.Fatal().Err(err).Msg("load token keys error")
+		}
+	}
+
+	validationPolicy := authusecase.ValidationPolicy{
+		LoginMinLength:         cfg.LoginMinLength,
+		LoginMaxLength:         cfg.LoginMaxLength,
+		PasswordMinLength:      cfg.PasswordMinLength,
+		PasswordMaxLength:      cfg.PasswordMaxLength,
+		PasswordMinCharClasses: cfg.PasswordMinCharClasses,
+	}
+	if cfg.BreachedPasswordsFile != "" {
+		validationPolicy.BreachedPasswords, err = authusecase.LoadBreachedPasswordList(cfg.BreachedPasswordsFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("load breached passwords error")
+		}
+	}
+
+	tokenSources, err := authhandlers.ParseTokenSources(cfg.AuthTokenSources)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("parse auth token sources error")
+	}
+
+	loginThrottler := throttle.NewThrottler(loginThrottleRepo, throttle.Policy{
+		FreeAttempts:       cfg.LoginFreeAttempts,
+		BaseDelay:          time.Duration(cfg.LoginDelayBase) * time.Second,
+		MaxDelay:           time.Duration(cfg.LoginDelayMax) * time.Second,
+		LockoutThreshold:   cfg.LoginLockoutThreshold,
+		LockoutDuration:    time.Duration(cfg.LoginLockoutDuration) * time.Second,
+		IPLockoutThreshold: cfg.LoginIPLockoutThreshold,
+		Window:             time.Duration(cfg.LoginFailureWindow) * time.Second,
+		AttemptsRetention:  time.Duration(cfg.LoginAttemptsRetention) * time.Second,
+	})
 
 	return &App{
 		config: cfg,
 		authUC: authusecase.NewAuthUseCase(userRepo,
-			cfg.HashSalt,
-			cfg.SigningKey,
-			cfg.TokenTTL),
-		orderUC: orderusecase.NewOrderUseCase(orderRepo),
+			sessionRepo,
+			hasher,
+			authusecase.NewValidator(validationPolicy),
+			loginThrottler,
+			keySet,
+			cfg.TokenTTL,
+			cfg.RefreshTokenTTL,
+			legacyTokensUntil),
+		orderUC:         orderusecase.NewOrderUseCase(orderRepo, accrualQueue),
+		loginThrottler:  loginThrottler,
+		accrualQueue:    accrualQueue,
+		idempotencyRepo: idempotencyRepo,
+		pool:            pool,
+		tokenSources:    tokenSources,
 	}
 }
 
 func (app *App) Run() error {
 	logger := log.With().Str("package", "app").Str("func", "run").Logger()
 
-	wg := &sync.WaitGroup{}
-	uChannel := make(chan *string)
-
 	logger.Debug().Msg("enter")
 	defer logger.Debug().Msg("exit")
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// jobs in flight get the shutdown timeout to finish, so they do not use the signal context
+	wg := &sync.WaitGroup{}
+	workersCtx, cancelWorkers := context.WithCancel(context.Background())
+	defer cancelWorkers()
+
+	err := app.orderUC.EnqueueNotFinnalizedOrders(ctx)
+	if err != nil {
+		logger.Debug().Err(err).Msg("enqueue not finnalized orders error")
+	}
+
+	accrualService := integration.NewAccurualService(wg,
+		integration.NewHTTPAccrualClient(app.config.AccrualSystemAddress,
+			&http.Client{Timeout: time.Duration(app.config.AccrualRequestTimeout) * time.Second}),
+		app.orderUC,
+		app.accrualQueue,
+		app.config.AccrualWorkers,
+		time.Duration(app.config.AccrualPollInterval)*time.Second,
+		time.Duration(app.config.AccrualJobLease)*time.Second,
+		integration.RetryPolicy{
+			InitialInterval: time.Duration(app.config.AccrualRetryInitialInterval) * time.Second,
+			MaxInterval:     time.Duration(app.config.AccrualRetryMaxInterval) * time.Second,
+			Multiplier:      app.config.AccrualRetryMultiplier,
+			Jitter:          app.config.AccrualRetryJitter,
+			MaxAttempts:     app.config.AccrualRetryMaxAttempts,
+			MaxElapsedTime:  time.Duration(app.config.AccrualRetryMaxElapsedTime) * time.Second,
+		},
+		integration.NewRateLimiter(app.config.AccrualRateLimit, app.config.AccrualRateBurst),
+		integration.NewCircuitBreaker(app.config.AccrualBreakerThreshold,
+			time.Duration(app.config.AccrualBreakerOpenTimeout)*time.Second))
+	accrualService.StartUpdateWorker(workersCtx)
+
+	// the purge stops with the signal, so the accrual shutdown does not wait for it
+	app.loginThrottler.StartPurgeWorker(ctx, wg, time.Hour)
+
+	publishStats("accrual", accrualService.Stats)
+	publishStats("login_throttle", app.loginThrottler.Stats)
+	publishStats("database", func() interface{} {
+		return database.PoolStats(app.pool)
+	})
+	publishStats("orders", app.orderLatency)
+
 	logger.Debug().Msg("create new gin engine object")
-	app.server = httpserver.NewGinEngine(wg, uChannel, app.authUC, app.orderUC, app.config.AccrualSystemAddress)
+	app.server = &http.Server{
+		Addr: app.config.RunAddress,
+		Handler: httpserver.NewGinEngine(app.authUC, app.orderUC,
+			app.idempotencyRepo, time.Duration(app.config.IdempotencyKeyTTL)*time.Second,
+			app.tokenSources, app.config.TrustedProxies,
+			map[string]httpserver.HealthCheck{
+				"accrual": func() (string, bool) {
+					state := accrualService.CircuitBreaker.State()
+					return state, state == integration.CircuitClosed
+				},
+				"database": app.databaseHealth,
+			}),
+	}
 
-	logger.Fatal().Err(app.server.Run(app.config.RunAddress))
+	serverErr := make(chan error, 2)
+	go func() {
+		serverErr <- app.server.ListenAndServe()
+	}()
 
-	close(uChannel)
+	var debugServer *http.Server
+	if app.config.DebugAddress != "" {
+		debugServer = &http.Server{
+			Addr:    app.config.DebugAddress,
+			Handler: httpserver.NewDebugHandler(),
+		}
+		go func() {
+			serverErr <- debugServer.ListenAndServe()
+		}()
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		logger.Info().Msg("shutdown signal received")
+	case runErr = <-serverErr:
+		logger.Error().Err(runErr).Msg("server error")
+	}
+	stop()
+
+	shutdownTimeout := time.Duration(app.config.ShutdownTimeout) * time.Second
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	if err := app.server.Shutdown(shutdownCtx); err != nil {
+		logger.Error().Err(err).Msg("server shutdown error")
+	}
+	if debugServer != nil {
+		if err := debugServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error().Err(err).Msg("debug server shutdown error")
+		}
+	}
+
+	if err := accrualService.Shutdown(shutdownCtx); err != nil {
+		logger.Error().Err(err).Msg("accrual workers shutdown timeout, cancel jobs in flight")
+	}
+	cancelWorkers()
 	wg.Wait()
-	return nil
+
+	if app.pool != nil {
+		app.pool.Close()
+	}
+
+	if errors.Is(runErr, http.ErrServerClosed) {
+		runErr = nil
+	}
+	return runErr
+}
+
+func (app *App) databaseHealth() (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := app.pool.Ping(ctx); err != nil {
+		return "down", false
+	}
+	return "up", true
+}
+
+// orderLatency is read on every scrape, the report is cached so
+// the scrapes do not hit the database.
+func (app *App) orderLatency() interface{} {
+	ttl := time.Duration(app.config.OrderLatencyCacheTTL) * time.Second
+	return app.latency.get(ttl, func() interface{} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		window := time.Duration(app.config.OrderLatencyWindow) * time.Second
+		report, err := app.orderUC.GetStatusLatency(ctx, time.Now().Add(-window))
+		if err != nil {
+			return map[string]string{"error": err.Error()}
+		}
+		return report
+	})
 }
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// expvar panics when a name is published twice, so every name is published
+// once and reads the stats of the app that runs last.
+var (
+	statsMutex sync.Mutex
+	stats      = make(map[string]func() interface{})
+)
+
+func publishStats(name string, f func() interface{}) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	if _, published := stats[name]; !published {
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			statsMutex.Lock()
+			f := stats[name]
+			statsMutex.Unlock()
+			return f()
+		}))
+	}
+	stats[name] = f
+}
+
+// cachedStats keeps a value for the ttl, the concurrent reads of
+// an expired value wait for a single refresh.
+type cachedStats struct {
+	mutex     sync.Mutex
+	value     interface{}
+	expiresAt time.Time
+}
+
+func (cs *cachedStats) get(ttl time.Duration, refresh func() interface{}) interface{} {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	now := time.Now()
+	if cs.value == nil || !now.Before(cs.expiresAt) {
+		cs.value = refresh()
+		cs.expiresAt = now.Add(ttl)
+	}
+	return cs.value
+}
@@ -7,4 +7,12 @@ var (
 	ErrInternalServer    = errors.New("internal server error")
 	ErrBadLoginPassword  = errors.New("bad login/password")
 	ErrUserNotExsist     = errors.New("user not exsist")
+
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrSessionRevoked      = errors.New("session revoked")
+	ErrNoSession           = errors.New("token has no session")
+
+	ErrTooManyAttempts = errors.New("too many login attempts")
+	ErrValidation      = errors.New("validation failed")
 )
@@ -3,8 +3,11 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
 	"github.com/alexkopcak/gophermart/internal/models"
@@ -39,13 +42,19 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
 	var err error
 	err = json.NewDecoder(c.Request.Body).Decode(&user)
 
-	if err != nil || user.UserName == "" {
-		logger.Debug().Str("user", user.UserName).Msg("exit with error: empty user name")
-		c.String(http.StatusBadRequest, "неверный формат запроса", err.Error())
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error: bad request body")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
 		return
 	}
 
 	err = h.AuthUseCase.SignUp(c.Request.Context(), user.UserName, user.Password)
+	var validationError *auth.ValidationError
+	if errors.As(err, &validationError) {
+		logger.Debug().Err(err).Msg("exit with error: validation failed")
+		c.JSON(http.StatusBadRequest, validationResponse{Errors: validationError.Violations})
+		return
+	}
 	if errors.Is(err, auth.ErrUserAlreadyExsist) {
 		logger.Debug().Msg("exit with error: user already exsist")
 		c.String(http.StatusConflict, "логин уже занят")
@@ -57,16 +66,15 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
 		return
 	}
 
-	token, err := h.AuthUseCase.SignIn(c.Request.Context(), user.UserName, user.Password)
+	token, err := h.AuthUseCase.SignIn(c.Request.Context(), user.UserName, user.Password, c.ClientIP())
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
 		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
 		return
 	}
 
-	c.SetCookie("Authorization", token, 3600, "/", "", false, false)
-	c.String(http.StatusOK, "пользователь успешно зарегистрирован и аутентифицирован")
 	logger.Debug().Msg("user autentithication success")
+	writeTokens(c, token)
 }
 
 func (h *AuthHandler) SignIn(c *gin.Context) {
@@ -92,8 +100,15 @@ func (h *AuthHandler) SignIn(c *gin.Context) {
 		return
 	}
 
-	token, err := h.AuthUseCase.SignIn(c.Request.Context(), user.UserName, user.Password)
-	if errors.Is(err, auth.ErrUserNotExsist) {
+	token, err := h.AuthUseCase.SignIn(c.Request.Context(), user.UserName, user.Password, c.ClientIP())
+	var tooManyAttempts *auth.TooManyAttemptsError
+	if errors.As(err, &tooManyAttempts) {
+		logger.Debug().Str("user.UserName", user.UserName).Dur("retryAfter", tooManyAttempts.RetryAfter).Msg("exit with error: too many attempts")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))))
+		c.String(http.StatusTooManyRequests, "слишком много попыток входа, повторите позже")
+		return
+	}
+	if errors.Is(err, auth.ErrUserNotExsist) || errors.Is(err, auth.ErrBadLoginPassword) {
 		logger.Debug().Msg("exit with error: bad user name or password")
 		c.String(http.StatusUnauthorized, "неверная пара логин/пароль")
 		return
@@ -105,8 +120,281 @@ func (h *AuthHandler) SignIn(c *gin.Context) {
 	}
 
 	logger.Debug().Msg("user authorization complete")
-	c.SetCookie("Authorization", token, 3600, "/", "", false, false)
-	c.String(http.StatusOK, "пользователь успешно аутентифицирован")
+	writeTokens(c, token)
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	logger := log.With().Str("package", "handlers").Str("function", "Refresh").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	refreshToken, _ := c.Cookie(auth.RefreshTokenCookie)
+	token, err := h.AuthUseCase.Refresh(c.Request.Context(), refreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) ||
+		errors.Is(err, auth.ErrRefreshTokenReused) ||
+		errors.Is(err, auth.ErrSessionRevoked) ||
+		errors.Is(err, auth.ErrUserNotExsist) {
+		logger.Debug().Err(err).Msg("exit with error")
+		clearTokenCookies(c)
+		c.String(http.StatusUnauthorized, "сессия недействительна")
+		return
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
+		return
+	}
+
+	writeTokens(c, token)
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	logger := log.With().Str("package", "handlers").Str("function", "Logout").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	refreshToken, _ := c.Cookie(auth.RefreshTokenCookie)
+	err := h.AuthUseCase.Logout(c.Request.Context(), refreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		logger.Debug().Err(err).Msg("exit with error")
+		clearTokenCookies(c)
+		c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+		return
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
+		return
+	}
+
+	clearTokenCookies(c)
+	c.String(http.StatusOK, "сессия завершена")
+}
+
+// LogoutSession ends the session the access token was issued in.
+func (h *AuthHandler) LogoutSession(c *gin.Context) {
+	logger := log.With().Str("package", "handlers").Str("function", "LogoutSession").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	value, _ := c.Get(auth.CtxPrincipalKey)
+	principal, ok := value.(*auth.Principal)
+	if !ok {
+		logger.Debug().Msg("exit with error: user not found")
+		c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+		return
+	}
+	err := h.AuthUseCase.LogoutSession(c.Request.Context(), principal.UserID, principal.SessionID)
+	if errors.Is(err, auth.ErrNoSession) {
+		logger.Debug().Err(err).Msg("exit with error")
+		c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+		return
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
+		return
+	}
+
+	clearTokenCookies(c)
+	c.String(http.StatusOK, "сессия завершена")
+}
+
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	logger := log.With().Str("package", "handlers").Str("function", "LogoutAll").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	user, _ := c.Get(auth.CtxUserKey)
+	userID, ok := user.(int32)
+	if !ok {
+		logger.Debug().Msg("exit with error: user not found")
+		c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+		return
+	}
+	if err := h.AuthUseCase.LogoutAll(c.Request.Context(), userID); err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
+		return
+	}
+
+	clearTokenCookies(c)
+	c.String(http.StatusOK, "все сессии завершены")
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	logger := log.With().Str("package", "handlers").Str("function", "ChangePassword").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	defer c.Request.Body.Close()
+
+	value, _ := c.Get(auth.CtxPrincipalKey)
+	principal, ok := value.(*auth.Principal)
+	if !ok {
+		logger.Debug().Msg("exit with error: user not found")
+		c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+		return
+	}
+
+	if strings.Compare(c.ContentType(), "application/json") != 0 {
+		logger.Debug().Str("ContentType", c.ContentType()).Msg("exit with error: bad content type")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
+		return
+	}
+
+	var request changePasswordRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		logger.Debug().Err(err).Msg("exit with error: bad request body")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
+		return
+	}
+
+	err := h.AuthUseCase.ChangePassword(c.Request.Context(), principal.UserID, principal.SessionID,
+		request.CurrentPassword, request.NewPassword, c.ClientIP())
+	if writePasswordCheckError(c, err) {
+		logger.Debug().Err(err).Msg("exit with error")
+		return
+	}
+	var validationError *auth.ValidationError
+	if errors.As(err, &validationError) {
+		logger.Debug().Err(err).Msg("exit with error: validation failed")
+		c.JSON(http.StatusBadRequest, validationResponse{Errors: validationError.Violations})
+		return
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
+		return
+	}
+
+	c.String(http.StatusOK, "пароль изменён")
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+func (h *AuthHandler) DeleteAccount(c *gin.Context) {
+	logger := log.With().Str("package", "handlers").Str("function", "DeleteAccount").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	defer c.Request.Body.Close()
+
+	user, _ := c.Get(auth.CtxUserKey)
+	userID, ok := user.(int32)
+	if !ok {
+		logger.Debug().Msg("exit with error: user not found")
+		c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+		return
+	}
+
+	if strings.Compare(c.ContentType(), "application/json") != 0 {
+		logger.Debug().Str("ContentType", c.ContentType()).Msg("exit with error: bad content type")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
+		return
+	}
+
+	var request deleteAccountRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		logger.Debug().Err(err).Msg("exit with error: bad request body")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
+		return
+	}
+
+	err := h.AuthUseCase.DeleteAccount(c.Request.Context(), userID, request.Password, c.ClientIP())
+	if writePasswordCheckError(c, err) {
+		logger.Debug().Err(err).Msg("exit with error")
+		return
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
+		return
+	}
+
+	clearTokenCookies(c)
+	c.String(http.StatusOK, "аккаунт удалён")
+}
+
+// writePasswordCheckError answers the errors of the password confirmation,
+// it returns false for the other errors.
+func writePasswordCheckError(c *gin.Context, err error) bool {
+	var tooManyAttempts *auth.TooManyAttemptsError
+	switch {
+	case errors.As(err, &tooManyAttempts):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))))
+		c.String(http.StatusTooManyRequests, "слишком много попыток входа, повторите позже")
+	case errors.Is(err, auth.ErrBadLoginPassword):
+		c.String(http.StatusForbidden, "неверный текущий пароль")
+	case errors.Is(err, auth.ErrUserNotExsist):
+		clearTokenCookies(c)
+		c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+	default:
+		return false
+	}
+	return true
+}
+
+// JWKS publishes the token verification keys for other services.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.AuthUseCase.JWKS(c.Request.Context()))
+}
+
+type validationResponse struct {
+	Errors []auth.Violation `json:"errors"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// writeTokens answers with the access token in the body, the Authorization
+// header and the cookie, so cookie and bearer clients get it the same way.
+func writeTokens(c *gin.Context, token *auth.Tokens) {
+	setTokenCookies(c, token)
+	c.Header("Authorization", bearerScheme+" "+token.AccessToken)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, tokenResponse{
+		AccessToken: token.AccessToken,
+		TokenType:   bearerScheme,
+		ExpiresIn:   maxAge(token.AccessExpiresAt),
+	})
+}
+
+// the refresh token is only sent to the refresh and logout endpoints under
+// the path and is not readable from scripts. The cookies set on the legacy
+// path are removed whenever the cookies change.
+const (
+	refreshTokenCookiePath       = "/api/user/token"
+	legacyRefreshTokenCookiePath = "/api/user"
+)
+
+func setTokenCookies(c *gin.Context, token *auth.Tokens) {
+	c.SetCookie(auth.AccessTokenCookie, token.AccessToken,
+		maxAge(token.AccessExpiresAt), "/", "", false, false)
+	c.SetCookie(auth.RefreshTokenCookie, token.RefreshToken,
+		maxAge(token.RefreshExpiresAt), refreshTokenCookiePath, "", false, true)
+	c.SetCookie(auth.RefreshTokenCookie, "", -1, legacyRefreshTokenCookiePath, "", false, true)
+}
+
+func clearTokenCookies(c *gin.Context) {
+	c.SetCookie(auth.AccessTokenCookie, "", -1, "/", "", false, false)
+	c.SetCookie(auth.RefreshTokenCookie, "", -1, refreshTokenCookiePath, "", false, true)
+	c.SetCookie(auth.RefreshTokenCookie, "", -1, legacyRefreshTokenCookiePath, "", false, true)
+}
+
+func maxAge(expiresAt time.Time) int {
+	return int(time.Until(expiresAt).Seconds())
 }
 
 func (h *AuthHandler) Test(c *gin.Context) {
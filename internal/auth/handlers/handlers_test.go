@@ -3,34 +3,349 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alexkopcak/gophermart/internal/auth"
 	"github.com/alexkopcak/gophermart/internal/auth/usecase"
 	"github.com/alexkopcak/gophermart/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestSignUp(t *testing.T) {
-	router := gin.Default()
+func newRouter(auc auth.UseCase, sources ...TokenSource) *gin.Engine {
+	gin.SetMode(gin.TestMode)
 
-	auc := new(usecase.AuthUseCaseMock)
+	router := gin.New()
+	middleware := AuthMiddlewareHandle(auc, sources...)
+	RegisterHTTPEndpoints(router, middleware, auc)
+	router.GET("/api/user/test", middleware, func(c *gin.Context) {
+		user, _ := c.Get(auth.CtxUserKey)
+		c.String(http.StatusOK, "%d", user)
+	})
+	return router
+}
+
+func newTokens() *auth.Tokens {
+	return &auth.Tokens{
+		AccessToken:      "access.token",
+		AccessExpiresAt:  time.Now().Add(10 * time.Minute),
+		RefreshToken:     "refresh.token",
+		RefreshExpiresAt: time.Now().Add(time.Hour),
+	}
+}
 
-	RegisterHTTPEndpoints(router, auc)
+func signInRequest(t *testing.T, url string, user *models.User) *http.Request {
+	body, err := json.Marshal(user)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestSignUp(t *testing.T) {
+	auc := new(usecase.AuthUseCaseMock)
+	router := newRouter(auc)
 
 	user := &models.User{
 		UserName: "testuser",
 		Password: "testpassword",
 	}
 
-	body, err := json.Marshal(user)
-	assert.NoError(t, err)
-
 	auc.On("SignUp", user.UserName, user.Password).Return(nil)
+	auc.On("SignIn", user.UserName, user.Password).Return(newTokens(), nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signInRequest(t, "/api/user/register", user))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Bearer access.token", w.Header().Get("Authorization"))
+	auc.AssertExpectations(t)
+}
+
+func TestSignUpValidation(t *testing.T) {
+	auc := new(usecase.AuthUseCaseMock)
+	router := newRouter(auc)
+
+	user := &models.User{UserName: "test user"}
+	violations := []auth.Violation{
+		{Field: auth.FieldLogin, Rule: auth.RuleCharset, Message: "login charset"},
+		{Field: auth.FieldPassword, Rule: auth.RuleRequired, Message: "password required"},
+	}
+	auc.On("SignUp", user.UserName, user.Password).Return(&auth.ValidationError{Violations: violations})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signInRequest(t, "/api/user/register", user))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response validationResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, violations, response.Errors)
+	auc.AssertNotCalled(t, "SignIn", user.UserName, user.Password)
+}
+
+func TestSignIn(t *testing.T) {
+	auc := new(usecase.AuthUseCaseMock)
+	router := newRouter(auc)
+
+	user := &models.User{UserName: "testuser", Password: "testpassword"}
+	auc.On("SignIn", user.UserName, user.Password).Return(newTokens(), nil)
+	auc.On("SignIn", user.UserName, "wrong").Return((*auth.Tokens)(nil), auth.ErrBadLoginPassword)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signInRequest(t, "/api/user/login", user))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response tokenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "access.token", response.AccessToken)
+	assert.Equal(t, "Bearer", response.TokenType)
+	assert.InDelta(t, 600, response.ExpiresIn, 5)
+
+	assert.Equal(t, "Bearer access.token", w.Header().Get("Authorization"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name+" "+cookie.Path] = cookie
+	}
+	require.Contains(t, cookies, auth.AccessTokenCookie+" /")
+	assert.Equal(t, "access.token", cookies[auth.AccessTokenCookie+" /"].Value)
+	// the refresh token is not sent with the other /api/user requests
+	require.Contains(t, cookies, auth.RefreshTokenCookie+" /api/user/token")
+	assert.Equal(t, "refresh.token", cookies[auth.RefreshTokenCookie+" /api/user/token"].Value)
+	assert.True(t, cookies[auth.RefreshTokenCookie+" /api/user/token"].HttpOnly)
+	require.Contains(t, cookies, auth.RefreshTokenCookie+" /api/user")
+	assert.Empty(t, cookies[auth.RefreshTokenCookie+" /api/user"].Value)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, signInRequest(t, "/api/user/login", &models.User{UserName: user.UserName, Password: "wrong"}))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Header().Get("Authorization"))
+}
+
+// browserRequest sends the cookies the way a browser does, only the ones
+// whose path covers the url.
+func browserRequest(url string, cookies []*http.Cookie) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, url, nil)
+	for _, cookie := range cookies {
+		if cookie.Value != "" && strings.HasPrefix(url, cookie.Path) {
+			req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+	return req
+}
+
+func TestLogout(t *testing.T) {
+	auc := new(usecase.AuthUseCaseMock)
+	router := newRouter(auc)
+
+	user := &models.User{UserName: "testuser", Password: "testpassword"}
+	auc.On("SignIn", user.UserName, user.Password).Return(newTokens(), nil)
+	auc.On("Logout", "refresh.token").Return(nil)
+	auc.On("Logout", "").Return(auth.ErrInvalidRefreshToken)
+	auc.On("ParseToken", "access.token").Return(&auth.Principal{UserID: 1, Login: "user", SessionID: "sid"}, nil)
+	auc.On("ParseToken", "legacy.token").Return(&auth.Principal{UserID: 1, Login: "user"}, nil)
+	auc.On("LogoutSession", int32(1), "sid").Return(nil)
+	auc.On("LogoutSession", int32(1), "").Return(auth.ErrNoSession)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signInRequest(t, "/api/user/login", user))
+	require.Equal(t, http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, browserRequest("/api/user/token/logout", cookies))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the refresh token is not presented, nothing is revoked
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, browserRequest("/api/user/token/logout", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// the refresh token is not sent to /api/user/logout, the access token is
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, browserRequest("/api/user/logout", cookies))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req := browserRequest("/api/user/logout", nil)
+	req.Header.Set("Authorization", "Bearer legacy.token")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, browserRequest("/api/user/logout", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	auc.AssertExpectations(t)
+	auc.AssertNumberOfCalls(t, "Logout", 2)
+}
+
+func TestSignInTooManyAttempts(t *testing.T) {
+	auc := new(usecase.AuthUseCaseMock)
+	router := newRouter(auc)
+
+	user := &models.User{UserName: "testuser", Password: "testpassword"}
+	auc.On("SignIn", user.UserName, user.Password).
+		Return((*auth.Tokens)(nil), &auth.TooManyAttemptsError{RetryAfter: 1500 * time.Millisecond})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signInRequest(t, "/api/user/login", user))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
+
+func authorizedRequest(t *testing.T, method string, url string, body interface{}) *http.Request {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(method, url, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer access.token")
+	return req
+}
+
+func TestChangePassword(t *testing.T) {
+	auc := new(usecase.AuthUseCaseMock)
+	router := newRouter(auc)
+	auc.On("ParseToken", "access.token").Return(&auth.Principal{UserID: 1, Login: "user", SessionID: "sid"}, nil)
+
+	auc.On("ChangePassword", int32(1), "sid", "password", "new password").Return(nil)
+	auc.On("ChangePassword", int32(1), "sid", "wrong", "new password").Return(auth.ErrBadLoginPassword)
+	auc.On("ChangePassword", int32(1), "sid", "password", "short").
+		Return(&auth.ValidationError{Violations: []auth.Violation{{Field: auth.FieldPassword, Rule: auth.RuleMinLength}}})
+
+	tt := []struct {
+		name    string
+		request changePasswordRequest
+		want    int
+	}{
+		{name: "changed", request: changePasswordRequest{CurrentPassword: "password", NewPassword: "new password"}, want: http.StatusOK},
+		{name: "wrong password", request: changePasswordRequest{CurrentPassword: "wrong", NewPassword: "new password"}, want: http.StatusForbidden},
+		{name: "weak password", request: changePasswordRequest{CurrentPassword: "password", NewPassword: "short"}, want: http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, authorizedRequest(t, http.MethodPut, "/api/user/password", tc.request))
+			assert.Equal(t, tc.want, w.Code)
+		})
+	}
 
 	w := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewBuffer(body))
+	req := authorizedRequest(t, http.MethodPut, "/api/user/password", tt[0].request)
+	req.Header.Del("Authorization")
 	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	auc := new(usecase.AuthUseCaseMock)
+	router := newRouter(auc)
+	auc.On("ParseToken", "access.token").Return(&auth.Principal{UserID: 1, Login: "user", SessionID: "sid"}, nil)
+	auc.On("DeleteAccount", int32(1), "password").Return(nil)
+	auc.On("DeleteAccount", int32(1), "wrong").Return(&auth.TooManyAttemptsError{RetryAfter: time.Minute})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authorizedRequest(t, http.MethodDelete, "/api/user", deleteAccountRequest{Password: "wrong"}))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authorizedRequest(t, http.MethodDelete, "/api/user", deleteAccountRequest{Password: "password"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		assert.Empty(t, cookie.Value, cookie.Name)
+		assert.Negative(t, cookie.MaxAge, cookie.Name)
+	}
+	auc.AssertExpectations(t)
+}
+
+func TestAuthMiddlewareTokenSources(t *testing.T) {
+	auc := new(usecase.AuthUseCaseMock)
+	auc.On("ParseToken", "cookie token").Return(&auth.Principal{UserID: 1, Login: "cookie"}, nil)
+	auc.On("ParseToken", "header token").Return(&auth.Principal{UserID: 2, Login: "header"}, nil)
+	auc.On("ParseToken", "bad token").Return((*auth.Principal)(nil), errors.New("bad token"))
+
+	tt := []struct {
+		name   string
+		cookie string
+		header string
+		want   map[string]string
+	}{
+		{
+			name:   "cookie only",
+			cookie: "cookie token",
+			want:   map[string]string{"cookie,header": "1", "header,cookie": "1", "header": ""},
+		},
+		{
+			name:   "bearer only",
+			header: "Bearer header token",
+			want:   map[string]string{"cookie,header": "2", "header,cookie": "2", "cookie": ""},
+		},
+		{
+			name:   "both",
+			cookie: "cookie token",
+			header: "bearer header token",
+			want:   map[string]string{"cookie,header": "1", "header,cookie": "2"},
+		},
+		{
+			name:   "invalid token of the preferred source",
+			cookie: "bad token",
+			header: "Bearer header token",
+			want:   map[string]string{"cookie,header": "", "header,cookie": "2"},
+		},
+		{
+			name:   "other scheme",
+			header: "Basic dXNlcjpwYXNz",
+			want:   map[string]string{"cookie,header": ""},
+		},
+	}
+
+	for _, tc := range tt {
+		for precedence, want := range tc.want {
+			t.Run(tc.name+"/"+precedence, func(t *testing.T) {
+				sources, err := ParseTokenSources(strings.Split(precedence, ","))
+				require.NoError(t, err)
+				router := newRouter(auc, sources...)
+
+				req := httptest.NewRequest(http.MethodGet, "/api/user/test", nil)
+				if tc.cookie != "" {
+					req.AddCookie(&http.Cookie{Name: auth.AccessTokenCookie, Value: tc.cookie})
+				}
+				if tc.header != "" {
+					req.Header.Set("Authorization", tc.header)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				if want == "" {
+					assert.Equal(t, http.StatusUnauthorized, w.Code)
+					return
+				}
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, want, w.Body.String())
+			})
+		}
+	}
+}
+
+func TestParseTokenSources(t *testing.T) {
+	sources, err := ParseTokenSources(nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTokenSources, sources)
+
+	sources, err = ParseTokenSources([]string{" Header ", "cookie"})
+	require.NoError(t, err)
+	assert.Equal(t, []TokenSource{TokenSourceHeader, TokenSourceCookie}, sources)
+
+	_, err = ParseTokenSources([]string{"query"})
+	assert.Error(t, err)
 }
@@ -1,38 +1,97 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
 
-func AuthMiddlewareHandle(auc auth.UseCase) gin.HandlerFunc {
+// TokenSource is where the middleware looks for the access token.
+type TokenSource string
+
+const (
+	TokenSourceCookie TokenSource = "cookie"
+	TokenSourceHeader TokenSource = "header"
+)
+
+const bearerScheme = "Bearer"
+
+// DefaultTokenSources keeps the cookie of the browser clients first.
+var DefaultTokenSources = []TokenSource{TokenSourceCookie, TokenSourceHeader}
+
+func ParseTokenSources(values []string) ([]TokenSource, error) {
+	sources := make([]TokenSource, 0, len(values))
+	for _, value := range values {
+		source := TokenSource(strings.ToLower(strings.TrimSpace(value)))
+		if source != TokenSourceCookie && source != TokenSourceHeader {
+			return nil, fmt.Errorf("unknown token source %q", value)
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return DefaultTokenSources, nil
+	}
+	return sources, nil
+}
+
+// AuthMiddlewareHandle authenticates the request with the token of the first
+// source that has one, the other sources are not looked at.
+func AuthMiddlewareHandle(auc auth.UseCase, sources ...TokenSource) gin.HandlerFunc {
+	if len(sources) == 0 {
+		sources = DefaultTokenSources
+	}
+
 	return func(c *gin.Context) {
 		logger := log.With().Str("package", "handlers").Str("func", "AuthMiddlewareHandle").Logger()
 
 		logger.Debug().Msg("enter")
 		defer logger.Debug().Msg("exit")
 
-		token, err := c.Cookie("Authorization")
-		logger.Debug().Str("token", token).Msg("get token value")
+		var token string
+		var source TokenSource
+		for _, source = range sources {
+			if token = tokenFrom(c, source); token != "" {
+				break
+			}
+		}
+		logger.Debug().Str("source", string(source)).Msg("get token value")
 
-		if token == "" || err != nil {
-			logger.Debug().Err(err).Msg("exit with error")
+		if token == "" {
+			logger.Debug().Msg("exit with error: no token")
 			c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+			c.Abort()
 			return
 		}
 
-		user, err := auc.ParseToken(c.Request.Context(), token)
-		logger.Debug().Str("user", user.UserName).Int32("userID", user.ID).Msg("user from jw token")
-
-		if err != nil || user.UserName == "" {
+		principal, err := auc.ParseToken(c.Request.Context(), token)
+		if err != nil || principal == nil || principal.UserID == 0 {
 			logger.Debug().Err(err).Msg("exit with error")
 			c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+			c.Abort()
 			return
 		}
-		c.Set(auth.CtxUserKey, user.ID)
+		logger.Debug().Str("user", principal.Login).Int32("userID", principal.UserID).Msg("user from jw token")
+		c.Set(auth.CtxUserKey, principal.UserID)
+		c.Set(auth.CtxPrincipalKey, principal)
 		c.Next()
 	}
 }
+
+func tokenFrom(c *gin.Context, source TokenSource) string {
+	switch source {
+	case TokenSourceCookie:
+		token, _ := c.Cookie(auth.AccessTokenCookie)
+		return token
+	case TokenSourceHeader:
+		parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], bearerScheme) {
+			return ""
+		}
+		return strings.TrimSpace(parts[1])
+	}
+	return ""
+}
@@ -5,10 +5,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterHTTPEndpoints(router *gin.Engine, auc auth.UseCase) {
+func RegisterHTTPEndpoints(router *gin.Engine, middleware gin.HandlerFunc, auc auth.UseCase) {
 	handler := NewAuthHandler(auc)
 
 	router.POST("/api/user/register", handler.SignUp)
 	router.POST("/api/user/login", handler.SignIn)
-
+	router.POST("/api/user/token/refresh", handler.Refresh)
+	router.POST("/api/user/token/logout", handler.Logout)
+	// the refresh token cookie is not sent here, the session of the access token is ended
+	router.POST("/api/user/logout", middleware, handler.LogoutSession)
+	router.POST("/api/user/logout-all", middleware, handler.LogoutAll)
+	router.PUT("/api/user/password", middleware, handler.ChangePassword)
+	router.DELETE("/api/user", middleware, handler.DeleteAccount)
+	router.GET("/.well-known/jwks.json", handler.JWKS)
 }
@@ -0,0 +1,12 @@
+package keys
+
+import "errors"
+
+var (
+	ErrNoSigningKey      = errors.New("no signing key")
+	ErrUnknownKey        = errors.New("unknown key id")
+	ErrKeyRetired        = errors.New("key retired")
+	ErrUnsupportedKey    = errors.New("unsupported key type")
+	ErrUnexpectedMethod  = errors.New("unexpected signing method")
+	ErrEdDSAVerification = errors.New("ed25519: verification error")
+)
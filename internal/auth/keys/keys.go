@@ -0,0 +1,228 @@
+// Package keys holds the keys that sign and verify access tokens.
+//
+// Asymmetric keys are loaded from a directory of PEM files, the file name
+// without the extension is the key id (kid). Private keys can sign, public
+// keys only verify. To rotate keys add the new private key, switch the
+// signing key id and, once the old tokens expired, replace the old private
+// key with its public part or remove it. When the asymmetric keys replace
+// the HMAC secret, the secret is kept as a verification key for a while.
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+	// NotAfter retires a verification key, zero keeps it.
+	NotAfter time.Time
+}
+
+// KeySet signs tokens with a single key and verifies them with any key of the set.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewHMACKeySet returns the HS256 key set used when no asymmetric keys are
+// configured. Its tokens have no kid and can only be verified with the secret.
+func NewHMACKeySet(secret []byte) *KeySet {
+	key := &Key{
+		Method:     jwt.SigningMethodHS256,
+		PrivateKey: secret,
+		PublicKey:  secret,
+	}
+	return &KeySet{
+		signing: key,
+		keys:    map[string]*Key{key.ID: key},
+	}
+}
+
+// NewHMACVerificationKey returns the HS256 key of NewHMACKeySet that only
+// verifies the tokens until notAfter.
+func NewHMACVerificationKey(secret []byte, notAfter time.Time) *Key {
+	return &Key{
+		Method:    jwt.SigningMethodHS256,
+		PublicKey: secret,
+		NotAfter:  notAfter,
+	}
+}
+
+// NewKeySet returns a key set signing with the key signingKeyID.
+// Empty signingKeyID selects the private key when there is only one.
+func NewKeySet(keys []*Key, signingKeyID string) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*Key, len(keys))}
+
+	var private []*Key
+	for _, key := range keys {
+		if _, ok := set.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		set.keys[key.ID] = key
+		if key.PrivateKey != nil {
+			private = append(private, key)
+		}
+	}
+
+	if signingKeyID == "" {
+		if len(private) != 1 {
+			return nil, fmt.Errorf("%w: %d private keys and no signing key id", ErrNoSigningKey, len(private))
+		}
+		set.signing = private[0]
+		return set, nil
+	}
+
+	key, ok := set.keys[signingKeyID]
+	if !ok || key.PrivateKey == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNoSigningKey, signingKeyID)
+	}
+	set.signing = key
+	return set, nil
+}
+
+// LoadKeySet reads every *.pem file of the directory, the verification keys
+// are added to them.
+func LoadKeySet(dir string, signingKeyID string, verificationKeys ...*Key) (*KeySet, error) {
+	logger := log.With().Str("package", "keys").Str("func", "LoadKeySet").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		key, err := ParseKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		logger.Debug().Str("kid", id).Str("alg", key.Method.Alg()).Bool("private", key.PrivateKey != nil).Msg("key loaded")
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(append(keys, verificationKeys...), signingKeyID)
+}
+
+// ParseKey parses a PEM encoded RSA or Ed25519 key, private (PKCS #1 or
+// PKCS #8) or public (PKIX).
+func ParseKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data", ErrUnsupportedKey)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = SigningMethodEd25519, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.PublicKey = SigningMethodEd25519, k
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, parsed)
+	}
+	return key, nil
+}
+
+// Sign returns the signed token with the kid header of the signing key.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	if ks.signing.ID != "" {
+		token.Header["kid"] = ks.signing.ID
+	}
+	return token.SignedString(ks.signing.PrivateKey)
+}
+
+// Keyfunc finds the verification key by the kid header, the token must be
+// signed with the algorithm of the key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedMethod, token.Method.Alg())
+	}
+	if !key.NotAfter.IsZero() && time.Now().After(key.NotAfter) {
+		return nil, fmt.Errorf("%w: %q", ErrKeyRetired, kid)
+	}
+	return key.PublicKey, nil
+}
+
+// JWKS returns the public keys of the set, the HMAC secret is never published.
+func (ks *KeySet) JWKS() *auth.JSONWebKeySet {
+	set := &auth.JSONWebKeySet{Keys: []auth.JSONWebKey{}}
+	for _, key := range ks.keys {
+		jwk := auth.JSONWebKey{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Method.Alg(),
+		}
+		switch k := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+	return set
+}
@@ -0,0 +1,165 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pem"), data, 0600))
+}
+
+func newClaims() jwt.Claims {
+	return jwt.StandardClaims{
+		Subject:   "1",
+		ExpiresAt: jwt.At(time.Now().Add(time.Minute)),
+	}
+}
+
+func parse(ks *KeySet, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.StandardClaims{}, ks.Keyfunc)
+	return err
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, dir, "2022-01", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	writePEM(t, dir, "2022-02", "PRIVATE KEY", der)
+
+	_, err = LoadKeySet(dir, "")
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	oldSet, err := LoadKeySet(dir, "2022-01")
+	require.NoError(t, err)
+	oldToken, err := oldSet.Sign(newClaims())
+	require.NoError(t, err)
+
+	newSet, err := LoadKeySet(dir, "2022-02")
+	require.NoError(t, err)
+	newToken, err := newSet.Sign(newClaims())
+	require.NoError(t, err)
+
+	token, _, err := new(jwt.Parser).ParseUnverified(newToken, &jwt.StandardClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "2022-02", token.Header["kid"])
+	assert.Equal(t, "EdDSA", token.Header["alg"])
+
+	assert.NoError(t, parse(newSet, oldToken))
+	assert.NoError(t, parse(newSet, newToken))
+
+	// the retired key is kept for verification only
+	pub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	writePEM(t, dir, "2022-01", "PUBLIC KEY", pub)
+	newSet, err = LoadKeySet(dir, "")
+	require.NoError(t, err)
+	assert.NoError(t, parse(newSet, oldToken))
+
+	_, err = LoadKeySet(dir, "2022-01")
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	jwks := newSet.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "2022-01", jwks.Keys[0].KeyID)
+	assert.Equal(t, "RSA", jwks.Keys[0].KeyType)
+	assert.Equal(t, "RS256", jwks.Keys[0].Algorithm)
+	assert.Equal(t, "AQAB", jwks.Keys[0].E)
+	assert.Equal(t, "2022-02", jwks.Keys[1].KeyID)
+	assert.Equal(t, "OKP", jwks.Keys[1].KeyType)
+	assert.Equal(t, "Ed25519", jwks.Keys[1].Curve)
+	assert.NotEmpty(t, jwks.Keys[1].X)
+}
+
+func TestKeyfuncRejectsForeignTokens(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ks, err := NewKeySet([]*Key{{
+		ID:         "main",
+		Method:     SigningMethodEd25519,
+		PrivateKey: edKey,
+		PublicKey:  edKey.Public(),
+	}}, "")
+	require.NoError(t, err)
+
+	// HS256 signed with the public key must not pass as the EdDSA key
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims())
+	forged.Header["kid"] = "main"
+	token, err := forged.SignedString([]byte(edKey.Public().(ed25519.PublicKey)))
+	require.NoError(t, err)
+	assert.Error(t, parse(ks, token))
+
+	token, err = NewHMACKeySet([]byte("secret")).Sign(newClaims())
+	require.NoError(t, err)
+	assert.Error(t, parse(ks, token))
+}
+
+func TestHMACKeySet(t *testing.T) {
+	ks := NewHMACKeySet([]byte("secret"))
+
+	token, err := ks.Sign(newClaims())
+	require.NoError(t, err)
+	assert.NoError(t, parse(ks, token))
+	assert.Error(t, parse(NewHMACKeySet([]byte("other secret")), token))
+
+	assert.Empty(t, ks.JWKS().Keys)
+}
+
+func TestHMACToAsymmetricKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	writePEM(t, dir, "2022-01", "PRIVATE KEY", der)
+
+	hmacToken, err := NewHMACKeySet([]byte("secret")).Sign(newClaims())
+	require.NoError(t, err)
+
+	// the secret only verifies, the private key is still the only signing key
+	ks, err := LoadKeySet(dir, "", NewHMACVerificationKey([]byte("secret"), time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	assert.NoError(t, parse(ks, hmacToken))
+
+	newToken, err := ks.Sign(newClaims())
+	require.NoError(t, err)
+	token, _, err := new(jwt.Parser).ParseUnverified(newToken, &jwt.StandardClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", token.Header["alg"])
+	assert.NoError(t, parse(ks, newToken))
+
+	// the secret is never published
+	require.Len(t, ks.JWKS().Keys, 1)
+
+	ks, err = LoadKeySet(dir, "", NewHMACVerificationKey([]byte("other secret"), time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	assert.Error(t, parse(ks, hmacToken))
+
+	ks, err = LoadKeySet(dir, "", NewHMACVerificationKey([]byte("secret"), time.Now().Add(-time.Second)))
+	require.NoError(t, err)
+	assert.Error(t, parse(ks, hmacToken))
+	token, _, err = new(jwt.Parser).ParseUnverified(hmacToken, &jwt.StandardClaims{})
+	require.NoError(t, err)
+	_, err = ks.Keyfunc(token)
+	assert.ErrorIs(t, err, ErrKeyRetired)
+	assert.NoError(t, parse(ks, newToken))
+}
@@ -0,0 +1,9 @@
+package password
+
+import "errors"
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrBadConfig        = errors.New("bad password hasher config")
+	ErrBadHashFormat    = errors.New("bad password hash format")
+)
@@ -0,0 +1,181 @@
+// Package password hashes user passwords. Hashes are stored in PHC string
+// format, so the algorithm and its cost can change without a migration:
+// old hashes keep working and are upgraded on the next successful login.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type Config struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+	// LegacySalt is the global salt of the SHA-1 hashes created
+	// before per-user salts, they are only verified, never created.
+	LegacySalt string
+}
+
+type Hasher struct {
+	config Config
+}
+
+func NewHasher(config Config) (*Hasher, error) {
+	switch config.Algorithm {
+	case AlgorithmArgon2id:
+		if config.Argon2.SaltLength == 0 {
+			config.Argon2.SaltLength = 16
+		}
+		if config.Argon2.KeyLength == 0 {
+			config.Argon2.KeyLength = 32
+		}
+		if config.Argon2.Memory == 0 || config.Argon2.Iterations == 0 || config.Argon2.Parallelism == 0 {
+			return nil, fmt.Errorf("%w: argon2id memory, iterations and parallelism must be set", ErrBadConfig)
+		}
+	case AlgorithmBcrypt:
+		if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("%w: bcrypt cost %d is out of range", ErrBadConfig, config.BcryptCost)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, config.Algorithm)
+	}
+	return &Hasher{config: config}, nil
+}
+
+// Hash returns the encoded hash with a fresh random salt.
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.config.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.config.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	params := h.config.Argon2
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password against the encoded hash. needsRehash is set
+// when the hash was made with another algorithm or cost than configured.
+func (h *Hasher) Verify(password string, encoded string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return false, false, nil
+		}
+		expected := h.config.Argon2
+		needsRehash = h.config.Algorithm != AlgorithmArgon2id ||
+			params.Memory != expected.Memory ||
+			params.Iterations != expected.Iterations ||
+			params.Parallelism != expected.Parallelism ||
+			params.KeyLength != expected.KeyLength ||
+			uint32(len(salt)) != expected.SaltLength
+		return true, needsRehash, nil
+
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, false, err
+		}
+		return true, h.config.Algorithm != AlgorithmBcrypt || cost != h.config.BcryptCost, nil
+
+	case isLegacyHash(encoded):
+		actual := legacyHash(password, h.config.LegacySalt)
+		if subtle.ConstantTimeCompare([]byte(actual), []byte(strings.ToLower(encoded))) != 1 {
+			return false, false, nil
+		}
+		return true, true, nil
+	}
+	return false, false, ErrBadHashFormat
+}
+
+func decodeArgon2id(encoded string) (*Argon2Params, []byte, []byte, error) {
+	// $argon2id$v=19$m=65536,t=3,p=2$salt$key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, ErrBadHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrBadHashFormat
+	}
+
+	params := &Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, ErrBadHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrBadHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrBadHashFormat
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func isLegacyHash(encoded string) bool {
+	if len(encoded) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+func legacyHash(password string, salt string) string {
+	pwd := sha1.New()
+	pwd.Write([]byte(password))
+	pwd.Write([]byte(salt))
+
+	return hex.EncodeToString(pwd.Sum(nil))
+}
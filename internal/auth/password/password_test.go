@@ -0,0 +1,115 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2 = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func newTestHasher(t *testing.T, config Config) *Hasher {
+	hasher, err := NewHasher(config)
+	require.NoError(t, err)
+	return hasher
+}
+
+func TestArgon2id(t *testing.T) {
+	hasher := newTestHasher(t, Config{Algorithm: AlgorithmArgon2id, Argon2: testArgon2})
+
+	hash, err := hasher.Hash("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	// every hash gets its own salt
+	other, err := hasher.Hash("secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	ok, needsRehash, err := hasher.Verify("secret", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+
+	ok, _, err = hasher.Verify("wrong", hash)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// a higher cost asks to rehash old hashes
+	stronger := newTestHasher(t, Config{
+		Algorithm: AlgorithmArgon2id,
+		Argon2:    Argon2Params{Memory: 2048, Iterations: 1, Parallelism: 1},
+	})
+	ok, needsRehash, err = stronger.Verify("secret", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+}
+
+func TestBcrypt(t *testing.T) {
+	hasher := newTestHasher(t, Config{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost})
+
+	hash, err := hasher.Hash("secret")
+	require.NoError(t, err)
+
+	ok, needsRehash, err := hasher.Verify("secret", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+
+	ok, _, err = hasher.Verify("wrong", hash)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// switching the algorithm upgrades bcrypt hashes
+	argon := newTestHasher(t, Config{Algorithm: AlgorithmArgon2id, Argon2: testArgon2})
+	ok, needsRehash, err = argon.Verify("secret", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+}
+
+func TestLegacySHA1(t *testing.T) {
+	hasher := newTestHasher(t, Config{Algorithm: AlgorithmArgon2id, Argon2: testArgon2, LegacySalt: "salt"})
+
+	// sha1("password" + "salt")
+	legacy := "c88e9c67041a74e0357befdff93f87dde0904214"
+
+	ok, needsRehash, err := hasher.Verify("password", legacy)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+
+	ok, _, err = hasher.Verify("wrong", legacy)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerifyBadHash(t *testing.T) {
+	hasher := newTestHasher(t, Config{Algorithm: AlgorithmArgon2id, Argon2: testArgon2})
+
+	for _, hash := range []string{
+		"",
+		"plain text",
+		"$argon2id$v=19$m=1024,t=1,p=1$salt",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5",
+	} {
+		_, _, err := hasher.Verify("secret", hash)
+		assert.ErrorIs(t, err, ErrBadHashFormat, hash)
+	}
+}
+
+func TestNewHasherConfig(t *testing.T) {
+	_, err := NewHasher(Config{Algorithm: "md5"})
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+
+	_, err = NewHasher(Config{Algorithm: AlgorithmArgon2id})
+	assert.ErrorIs(t, err, ErrBadConfig)
+
+	_, err = NewHasher(Config{Algorithm: AlgorithmBcrypt, BcryptCost: 100})
+	assert.ErrorIs(t, err, ErrBadConfig)
+}
@@ -0,0 +1,11 @@
+package auth
+
+const RoleUser = "user"
+
+// Principal is the user an access token was issued to.
+type Principal struct {
+	UserID    int32
+	Login     string
+	SessionID string
+	Roles     []string
+}
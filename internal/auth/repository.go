@@ -2,11 +2,47 @@ package auth
 
 import (
 	"context"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/models"
 )
 
+// UserRepository.DeleteUser anonymises the user instead of removing the row,
+// the orders and the journal of the user are kept for the accounting. The
+// remaining points are forfeited and the orders still waiting for accrual
+// are stopped.
 type UserRepository interface {
 	CreateUser(ctx context.Context, user *models.User) error
 	GetUser(ctx context.Context, userName string) (*models.User, error)
+	GetUserByID(ctx context.Context, userID int32) (*models.User, error)
+	UpdateUserPassword(ctx context.Context, userID int32, passwordHash string) error
+	DeleteUser(ctx context.Context, userID int32) error
+}
+
+// SessionRepository keeps login sessions. RotateRefreshToken marks the old
+// token used and stores the new one atomically; it revokes the session and
+// returns ErrRefreshTokenReused when the old token was already used.
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *Session, token *RefreshToken) error
+	RotateRefreshToken(ctx context.Context, oldHash string, token *RefreshToken, now time.Time) (*Session, error)
+	RevokeSessionByToken(ctx context.Context, tokenHash string) error
+	RevokeSession(ctx context.Context, userID int32, sessionID string) error
+	// RevokeUserSessions keeps the exceptSessionID session, pass "" to revoke all.
+	RevokeUserSessions(ctx context.Context, userID int32, exceptSessionID string) error
+}
+
+// LoginThrottleRepository counts failed logins by key, a key is a login or
+// a client address. AddLoginFailure restarts the count when the previous
+// failure is older than the window.
+type LoginThrottleRepository interface {
+	AddLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error)
+	BlockLogin(ctx context.Context, key string, until time.Time) error
+	GetBlockedUntil(ctx context.Context, keys ...string) (time.Time, error)
+	ResetLoginFailures(ctx context.Context, key string) error
+	AddLoginAttempt(ctx context.Context, attempt *LoginAttempt) error
+	// PurgeLoginAttempts deletes the audit records made before the time.
+	PurgeLoginAttempts(ctx context.Context, before time.Time) error
+	// PurgeLoginThrottles deletes the keys failed last before failedBefore
+	// and not blocked at now.
+	PurgeLoginThrottles(ctx context.Context, failedBefore time.Time, now time.Time) error
 }
@@ -2,22 +2,33 @@ package localstorage
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/ledger"
 
 	"github.com/alexkopcak/gophermart/internal/models"
 )
 
 type UserLocalStrage struct {
-	users map[string]*models.User
-	mutex *sync.Mutex
+	users  map[string]*models.User
+	lastID int32
+	// ledger is optional, the remaining points of deleted users are forfeited to it.
+	ledger ledger.Repository
+	mutex  *sync.Mutex
 }
 
 func NewUserLocalStorage() auth.UserRepository {
+	return NewUserLocalStorageWithLedger(nil)
+}
+
+func NewUserLocalStorageWithLedger(ledgerRepo ledger.Repository) auth.UserRepository {
 	return &UserLocalStrage{
-		users: make(map[string]*models.User),
-		mutex: new(sync.Mutex),
+		users:  make(map[string]*models.User),
+		ledger: ledgerRepo,
+		mutex:  new(sync.Mutex),
 	}
 }
 
@@ -25,10 +36,16 @@ func (uls *UserLocalStrage) CreateUser(ctx context.Context, user *models.User) e
 	uls.mutex.Lock()
 	defer uls.mutex.Unlock()
 
-	if _, exsist := uls.users[user.UserName]; exsist {
-		return auth.ErrUserAlreadyExsist
+	// the logins are unique by the normalized form like in the database
+	login := auth.NormalizeLogin(user.UserName)
+	for userName := range uls.users {
+		if auth.NormalizeLogin(userName) == login {
+			return auth.ErrUserAlreadyExsist
+		}
 	}
 
+	uls.lastID++
+	user.ID = uls.lastID
 	uls.users[user.UserName] = user
 	return nil
 }
@@ -42,3 +59,57 @@ func (uls *UserLocalStrage) GetUser(ctx context.Context, userName string) (*mode
 	}
 	return uls.users[userName], nil
 }
+
+func (uls *UserLocalStrage) GetUserByID(ctx context.Context, userID int32) (*models.User, error) {
+	uls.mutex.Lock()
+	defer uls.mutex.Unlock()
+
+	for _, user := range uls.users {
+		if user.ID == userID {
+			return user, nil
+		}
+	}
+	return nil, auth.ErrUserNotExsist
+}
+
+func (uls *UserLocalStrage) UpdateUserPassword(ctx context.Context, userID int32, passwordHash string) error {
+	uls.mutex.Lock()
+	defer uls.mutex.Unlock()
+
+	for _, user := range uls.users {
+		if user.ID == userID {
+			user.Password = passwordHash
+			return nil
+		}
+	}
+	return auth.ErrUserNotExsist
+}
+
+func (uls *UserLocalStrage) DeleteUser(ctx context.Context, userID int32) error {
+	uls.mutex.Lock()
+	defer uls.mutex.Unlock()
+
+	for login, user := range uls.users {
+		if user.ID != userID {
+			continue
+		}
+
+		if uls.ledger != nil {
+			balance, err := uls.ledger.GetBalance(ctx, userID, time.Time{})
+			if err != nil {
+				return err
+			}
+			if balance.Current > 0 {
+				err = uls.ledger.PostEntry(ctx, ledger.NewForfeitEntry(userID, balance.Current))
+				if err != nil && !errors.Is(err, ledger.ErrEntryAlreadyPosted) {
+					return err
+				}
+			}
+		}
+
+		// the id is never reused, so the orders of the user stay anonymous
+		delete(uls.users, login)
+		return nil
+	}
+	return auth.ErrUserNotExsist
+}
@@ -3,10 +3,14 @@ package localstorage
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/ledger"
+	ledgerlocalstorage "github.com/alexkopcak/gophermart/internal/ledger/repository/localstorage"
 	"github.com/alexkopcak/gophermart/internal/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetUser(t *testing.T) {
@@ -30,3 +34,83 @@ func TestGetUser(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, err, auth.ErrUserNotExsist)
 }
+
+func TestUpdateUserPassword(t *testing.T) {
+	storage := NewUserLocalStorage()
+
+	first := &models.User{UserName: "first", Password: "first hash"}
+	second := &models.User{UserName: "second", Password: "second hash"}
+	assert.NoError(t, storage.CreateUser(context.Background(), first))
+	assert.NoError(t, storage.CreateUser(context.Background(), second))
+	assert.NotEqual(t, first.ID, second.ID)
+
+	assert.NoError(t, storage.UpdateUserPassword(context.Background(), second.ID, "new hash"))
+
+	getUser, err := storage.GetUser(context.Background(), "second")
+	assert.NoError(t, err)
+	assert.Equal(t, "new hash", getUser.Password)
+
+	getUser, err = storage.GetUser(context.Background(), "first")
+	assert.NoError(t, err)
+	assert.Equal(t, "first hash", getUser.Password)
+
+	err = storage.UpdateUserPassword(context.Background(), 100, "hash")
+	assert.Equal(t, auth.ErrUserNotExsist, err)
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	ledgerRepo := ledgerlocalstorage.NewLedgerLocalStorage()
+	storage := NewUserLocalStorageWithLedger(ledgerRepo)
+
+	user := &models.User{UserName: "user", Password: "hash"}
+	require.NoError(t, storage.CreateUser(ctx, user))
+	require.NoError(t, ledgerRepo.PostEntry(ctx, ledger.NewAccrualEntry(user.ID, "12345678903", 50050)))
+
+	require.NoError(t, storage.DeleteUser(ctx, user.ID))
+
+	_, err := storage.GetUser(ctx, "user")
+	assert.Equal(t, auth.ErrUserNotExsist, err)
+	_, err = storage.GetUserByID(ctx, user.ID)
+	assert.Equal(t, auth.ErrUserNotExsist, err)
+	assert.Equal(t, auth.ErrUserNotExsist, storage.DeleteUser(ctx, user.ID))
+
+	balance, err := ledgerRepo.GetBalance(ctx, user.ID, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), balance.Current)
+
+	entries, err := ledgerRepo.GetEntriesByUserID(ctx, user.ID, &ledger.EntryFilter{Kind: ledger.EntryKindForfeit})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(50050), entries[0].Amount(ledger.SystemUserID, ledger.AccountKindForfeited))
+
+	// the login is free again, the new user gets a new id
+	other := &models.User{UserName: "user", Password: "hash"}
+	require.NoError(t, storage.CreateUser(ctx, other))
+	assert.NotEqual(t, user.ID, other.ID)
+}
+
+func TestPurgeLoginThrottle(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	storage := NewLoginThrottleLocalStorage().(*LoginThrottleLocalStorage)
+
+	require.NoError(t, storage.AddLoginAttempt(ctx, &auth.LoginAttempt{Login: "old", AttemptedAt: now.Add(-2 * time.Hour)}))
+	require.NoError(t, storage.AddLoginAttempt(ctx, &auth.LoginAttempt{Login: "new", AttemptedAt: now}))
+	_, err := storage.AddLoginFailure(ctx, "login:old", now.Add(-2*time.Hour), time.Hour)
+	require.NoError(t, err)
+	_, err = storage.AddLoginFailure(ctx, "login:blocked", now.Add(-2*time.Hour), time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, storage.BlockLogin(ctx, "login:blocked", now.Add(time.Minute)))
+	_, err = storage.AddLoginFailure(ctx, "login:new", now, time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, storage.PurgeLoginAttempts(ctx, now.Add(-time.Hour)))
+	require.NoError(t, storage.PurgeLoginThrottles(ctx, now.Add(-time.Hour), now))
+
+	require.Len(t, storage.attempts, 1)
+	assert.Equal(t, "new", storage.attempts[0].Login)
+	assert.NotContains(t, storage.throttles, "login:old")
+	assert.Contains(t, storage.throttles, "login:blocked")
+	assert.Contains(t, storage.throttles, "login:new")
+}
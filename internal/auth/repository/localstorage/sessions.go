@@ -0,0 +1,102 @@
+package localstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+)
+
+type SessionLocalStorage struct {
+	sessions map[string]*auth.Session
+	tokens   map[string]*auth.RefreshToken
+	mutex    *sync.Mutex
+}
+
+func NewSessionLocalStorage() auth.SessionRepository {
+	return &SessionLocalStorage{
+		sessions: make(map[string]*auth.Session),
+		tokens:   make(map[string]*auth.RefreshToken),
+		mutex:    new(sync.Mutex),
+	}
+}
+
+func (sls *SessionLocalStorage) CreateSession(ctx context.Context, session *auth.Session, token *auth.RefreshToken) error {
+	sls.mutex.Lock()
+	defer sls.mutex.Unlock()
+
+	stored := *session
+	sls.sessions[session.ID] = &stored
+
+	storedToken := *token
+	storedToken.SessionID = session.ID
+	sls.tokens[token.Hash] = &storedToken
+	return nil
+}
+
+func (sls *SessionLocalStorage) RotateRefreshToken(ctx context.Context, oldHash string, token *auth.RefreshToken, now time.Time) (*auth.Session, error) {
+	sls.mutex.Lock()
+	defer sls.mutex.Unlock()
+
+	old, exsist := sls.tokens[oldHash]
+	if !exsist {
+		return nil, auth.ErrInvalidRefreshToken
+	}
+	session := sls.sessions[old.SessionID]
+	if session.Revoked() {
+		return nil, auth.ErrSessionRevoked
+	}
+	if old.Used() {
+		session.RevokedAt = now
+		return nil, auth.ErrRefreshTokenReused
+	}
+	if !now.Before(old.ExpiresAt) {
+		return nil, auth.ErrInvalidRefreshToken
+	}
+
+	old.UsedAt = now
+	token.SessionID = session.ID
+	storedToken := *token
+	sls.tokens[token.Hash] = &storedToken
+
+	result := *session
+	return &result, nil
+}
+
+func (sls *SessionLocalStorage) RevokeSessionByToken(ctx context.Context, tokenHash string) error {
+	sls.mutex.Lock()
+	defer sls.mutex.Unlock()
+
+	token, exsist := sls.tokens[tokenHash]
+	if !exsist {
+		return auth.ErrInvalidRefreshToken
+	}
+	if session := sls.sessions[token.SessionID]; !session.Revoked() {
+		session.RevokedAt = time.Now()
+	}
+	return nil
+}
+
+func (sls *SessionLocalStorage) RevokeSession(ctx context.Context, userID int32, sessionID string) error {
+	sls.mutex.Lock()
+	defer sls.mutex.Unlock()
+
+	if session, exsist := sls.sessions[sessionID]; exsist && session.UserID == userID && !session.Revoked() {
+		session.RevokedAt = time.Now()
+	}
+	return nil
+}
+
+func (sls *SessionLocalStorage) RevokeUserSessions(ctx context.Context, userID int32, exceptSessionID string) error {
+	sls.mutex.Lock()
+	defer sls.mutex.Unlock()
+
+	now := time.Now()
+	for _, session := range sls.sessions {
+		if session.UserID == userID && session.ID != exceptSessionID && !session.Revoked() {
+			session.RevokedAt = now
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package localstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+)
+
+type loginThrottle struct {
+	failures      int
+	lastFailureAt time.Time
+	blockedUntil  time.Time
+}
+
+type LoginThrottleLocalStorage struct {
+	throttles map[string]*loginThrottle
+	attempts  []auth.LoginAttempt
+	mutex     *sync.Mutex
+}
+
+func NewLoginThrottleLocalStorage() auth.LoginThrottleRepository {
+	return &LoginThrottleLocalStorage{
+		throttles: make(map[string]*loginThrottle),
+		mutex:     new(sync.Mutex),
+	}
+}
+
+func (lts *LoginThrottleLocalStorage) AddLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	lts.mutex.Lock()
+	defer lts.mutex.Unlock()
+
+	throttle, ok := lts.throttles[key]
+	if !ok {
+		throttle = &loginThrottle{}
+		lts.throttles[key] = throttle
+	}
+	if throttle.lastFailureAt.Before(now.Add(-window)) {
+		throttle.failures = 0
+	}
+	throttle.failures++
+	throttle.lastFailureAt = now
+	return throttle.failures, nil
+}
+
+func (lts *LoginThrottleLocalStorage) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	lts.mutex.Lock()
+	defer lts.mutex.Unlock()
+
+	if throttle, ok := lts.throttles[key]; ok && until.After(throttle.blockedUntil) {
+		throttle.blockedUntil = until
+	}
+	return nil
+}
+
+func (lts *LoginThrottleLocalStorage) GetBlockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	lts.mutex.Lock()
+	defer lts.mutex.Unlock()
+
+	var blockedUntil time.Time
+	for _, key := range keys {
+		if throttle, ok := lts.throttles[key]; ok && throttle.blockedUntil.After(blockedUntil) {
+			blockedUntil = throttle.blockedUntil
+		}
+	}
+	return blockedUntil, nil
+}
+
+func (lts *LoginThrottleLocalStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	lts.mutex.Lock()
+	defer lts.mutex.Unlock()
+
+	delete(lts.throttles, key)
+	return nil
+}
+
+func (lts *LoginThrottleLocalStorage) AddLoginAttempt(ctx context.Context, attempt *auth.LoginAttempt) error {
+	lts.mutex.Lock()
+	defer lts.mutex.Unlock()
+
+	lts.attempts = append(lts.attempts, *attempt)
+	return nil
+}
+
+func (lts *LoginThrottleLocalStorage) PurgeLoginAttempts(ctx context.Context, before time.Time) error {
+	lts.mutex.Lock()
+	defer lts.mutex.Unlock()
+
+	attempts := lts.attempts[:0]
+	for _, attempt := range lts.attempts {
+		if !attempt.AttemptedAt.Before(before) {
+			attempts = append(attempts, attempt)
+		}
+	}
+	lts.attempts = attempts
+	return nil
+}
+
+func (lts *LoginThrottleLocalStorage) PurgeLoginThrottles(ctx context.Context, failedBefore time.Time, now time.Time) error {
+	lts.mutex.Lock()
+	defer lts.mutex.Unlock()
+
+	for key, throttle := range lts.throttles {
+		if throttle.lastFailureAt.Before(failedBefore) && !throttle.blockedUntil.After(now) {
+			delete(lts.throttles, key)
+		}
+	}
+	return nil
+}
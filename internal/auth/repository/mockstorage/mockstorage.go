@@ -22,3 +22,21 @@ func (usm *UserStorageMock) GetUser(ctx context.Context, userName string) (*mode
 
 	return args.Get(0).(*models.User), args.Error(1)
 }
+
+func (usm *UserStorageMock) GetUserByID(ctx context.Context, userID int32) (*models.User, error) {
+	args := usm.Called(userID)
+
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (usm *UserStorageMock) UpdateUserPassword(ctx context.Context, userID int32, passwordHash string) error {
+	args := usm.Called(userID, passwordHash)
+
+	return args.Error(0)
+}
+
+func (usm *UserStorageMock) DeleteUser(ctx context.Context, userID int32) error {
+	args := usm.Called(userID)
+
+	return args.Error(0)
+}
@@ -3,31 +3,28 @@ package postgres
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/ledger"
+	ledgerdb "github.com/alexkopcak/gophermart/internal/ledger/repository/postgres"
 	"github.com/alexkopcak/gophermart/internal/models"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog/log"
 )
 
 type PostgresStorage struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewPostgresStorage(dbURI string) auth.UserRepository {
+func NewPostgresStorage(pool *pgxpool.Pool) auth.UserRepository {
 	logger := log.With().Str("package", "postgres").Str("func", "NewPostgresStroage").Logger()
 	logger.Debug().Msg("new postgres storage")
-	MakeMigrations(dbURI)
 
-	conn, err := pgx.Connect(context.Background(), dbURI)
-	if err != nil {
-		logger.Fatal().Err(err)
-	}
 	return &PostgresStorage{
-		db: conn,
+		db: pool,
 	}
 }
 
@@ -72,7 +69,7 @@ func (ps *PostgresStorage) GetUser(ctx context.Context, userName string) (*model
 	err := ps.db.QueryRow(ctx,
 		"SELECT id, login, password "+
 			"FROM users "+
-			"WHERE login = $1 "+
+			"WHERE (login = $1) AND (deleted_at IS NULL) "+
 			"LIMIT 1", userName).Scan(&user.ID, &user.UserName, &user.Password)
 	if errors.Is(err, pgx.ErrNoRows) || user.UserName == "" {
 		logger.Debug().Str("user", userName).Msg("user not exsist")
@@ -86,3 +83,116 @@ func (ps *PostgresStorage) GetUser(ctx context.Context, userName string) (*model
 	logger.Debug().Str("user", userName).Int32("userID", user.ID).Msg("user finded at storage")
 	return user, nil
 }
+
+func (ps *PostgresStorage) GetUserByID(ctx context.Context, userID int32) (*models.User, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "GetUserByID").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	var user = new(models.User)
+	err := ps.db.QueryRow(ctx,
+		"SELECT id, login, password "+
+			"FROM users "+
+			"WHERE (id = $1) AND (deleted_at IS NULL)", userID).Scan(&user.ID, &user.UserName, &user.Password)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Debug().Int32("userID", userID).Msg("user not exsist")
+		return nil, auth.ErrUserNotExsist
+	}
+	if err != nil {
+		logger.Err(err).Msg("exit with error")
+		return nil, err
+	}
+	return user, nil
+}
+
+func (ps *PostgresStorage) UpdateUserPassword(ctx context.Context, userID int32, passwordHash string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "UpdateUserPassword").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	tag, err := ps.db.Exec(ctx,
+		"UPDATE users "+
+			"SET password = $2 "+
+			"WHERE (id = $1) AND (deleted_at IS NULL)", userID, passwordHash)
+	if err != nil {
+		logger.Err(err).Msg("exit with error")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		logger.Debug().Int32("userID", userID).Msg("user not exsist")
+		return auth.ErrUserNotExsist
+	}
+
+	logger.Debug().Int32("userID", userID).Msg("password updated")
+	return nil
+}
+
+func (ps *PostgresStorage) DeleteUser(ctx context.Context, userID int32) error {
+	logger := log.With().Str("package", "postgres").Str("func", "DeleteUser").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	err := ps.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx,
+			"UPDATE users "+
+				"SET login = 'deleted:' || id, password = '', deleted_at = NOW() "+
+				"WHERE (id = $1) AND (deleted_at IS NULL)", userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return auth.ErrUserNotExsist
+		}
+
+		ledgerTx := ledgerdb.NewLedgerPostgresStorage(tx)
+		err = ledgerTx.LockAccount(ctx, ledger.Account{UserID: userID, Kind: ledger.AccountKindPoints})
+		if err != nil {
+			return err
+		}
+		balance, err := ledgerTx.GetBalance(ctx, userID, time.Time{})
+		if err != nil {
+			return err
+		}
+		if balance.Current > 0 {
+			err = ledgerTx.PostEntry(ctx, ledger.NewForfeitEntry(userID, balance.Current))
+			if err != nil && !errors.Is(err, ledger.ErrEntryAlreadyPosted) {
+				return err
+			}
+			logger.Debug().Int32("userID", userID).Int64("amount", balance.Current).Msg("points forfeited")
+		}
+
+		// the orders keep the user id, nobody is left to receive their accrual
+		_, err = tx.Exec(ctx,
+			"UPDATE orders "+
+				"SET stuck_at = NOW(), stuck_reason = 'account deleted' "+
+				"WHERE (user_id = $1) AND order_status NOT IN ('PROCESSED', 'INVALID') AND (stuck_at IS NULL)", userID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx,
+			"DELETE FROM accrual_jobs "+
+				"WHERE order_id IN (SELECT order_id FROM orders WHERE user_id = $1)", userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			"DELETE FROM idempotency_keys "+
+				"WHERE user_id = $1", userID)
+		return err
+	})
+	if errors.Is(err, auth.ErrUserNotExsist) {
+		logger.Debug().Int32("userID", userID).Msg("user not exsist")
+		return err
+	}
+	if err != nil {
+		logger.Err(err).Msg("exit with error")
+		return err
+	}
+
+	logger.Debug().Int32("userID", userID).Msg("user deleted")
+	return nil
+}
@@ -0,0 +1,179 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+type SessionPostgresStorage struct {
+	db *pgxpool.Pool
+}
+
+func NewSessionPostgresStorage(pool *pgxpool.Pool) auth.SessionRepository {
+	return &SessionPostgresStorage{
+		db: pool,
+	}
+}
+
+func (sps *SessionPostgresStorage) CreateSession(ctx context.Context, session *auth.Session, token *auth.RefreshToken) error {
+	logger := log.With().Str("package", "postgres").Str("func", "CreateSession").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	err := sps.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO sessions "+
+				"(id, user_id, created_at) "+
+				"VALUES ($1, $2, $3)", session.ID, session.UserID, session.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			"INSERT INTO refresh_tokens "+
+				"(token_hash, session_id, expires_at) "+
+				"VALUES ($1, $2, $3)", token.Hash, session.ID, token.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+
+	logger.Debug().Int32("userID", session.UserID).Str("sessionID", session.ID).Msg("session created")
+	return nil
+}
+
+func (sps *SessionPostgresStorage) RotateRefreshToken(ctx context.Context, oldHash string, token *auth.RefreshToken, now time.Time) (*auth.Session, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "RotateRefreshToken").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	tx, err := sps.db.Begin(ctx)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var session = new(auth.Session)
+	var expiresAt time.Time
+	var usedAt, revokedAt *time.Time
+	err = tx.QueryRow(ctx,
+		"SELECT s.id, s.user_id, s.created_at, s.revoked_at, t.expires_at, t.used_at "+
+			"FROM refresh_tokens t JOIN sessions s ON s.id = t.session_id "+
+			"WHERE t.token_hash = $1 "+
+			"FOR UPDATE", oldHash).
+		Scan(&session.ID, &session.UserID, &session.CreatedAt, &revokedAt, &expiresAt, &usedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, auth.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	if revokedAt != nil {
+		return nil, auth.ErrSessionRevoked
+	}
+
+	if usedAt != nil {
+		logger.Warn().Int32("userID", session.UserID).Str("sessionID", session.ID).Msg("refresh token reused, revoke session")
+		_, err = tx.Exec(ctx,
+			"UPDATE sessions SET revoked_at = $2 WHERE id = $1", session.ID, now)
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+		if err != nil {
+			logger.Debug().Err(err).Msg("exit with error")
+			return nil, err
+		}
+		return nil, auth.ErrRefreshTokenReused
+	}
+
+	if !now.Before(expiresAt) {
+		return nil, auth.ErrInvalidRefreshToken
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE refresh_tokens SET used_at = $2 WHERE token_hash = $1", oldHash, now)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		"INSERT INTO refresh_tokens "+
+			"(token_hash, session_id, expires_at) "+
+			"VALUES ($1, $2, $3)", token.Hash, session.ID, token.ExpiresAt)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	token.SessionID = session.ID
+	logger.Debug().Str("sessionID", session.ID).Msg("refresh token rotated")
+	return session, nil
+}
+
+func (sps *SessionPostgresStorage) RevokeSessionByToken(ctx context.Context, tokenHash string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "RevokeSessionByToken").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	cTag, err := sps.db.Exec(ctx,
+		"UPDATE sessions SET revoked_at = COALESCE(revoked_at, NOW()) "+
+			"WHERE id = (SELECT session_id FROM refresh_tokens WHERE token_hash = $1)", tokenHash)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+	if cTag.RowsAffected() == 0 {
+		return auth.ErrInvalidRefreshToken
+	}
+	return nil
+}
+
+func (sps *SessionPostgresStorage) RevokeSession(ctx context.Context, userID int32, sessionID string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "RevokeSession").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := sps.db.Exec(ctx,
+		"UPDATE sessions SET revoked_at = NOW() "+
+			"WHERE (id = $1) AND (user_id = $2) AND (revoked_at IS NULL)", sessionID, userID)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
+
+func (sps *SessionPostgresStorage) RevokeUserSessions(ctx context.Context, userID int32, exceptSessionID string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "RevokeUserSessions").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := sps.db.Exec(ctx,
+		"UPDATE sessions SET revoked_at = NOW() "+
+			"WHERE (user_id = $1) AND (revoked_at IS NULL) AND (id <> $2)", userID, exceptSessionID)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+type LoginThrottlePostgresStorage struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginThrottlePostgresStorage(pool *pgxpool.Pool) auth.LoginThrottleRepository {
+	return &LoginThrottlePostgresStorage{
+		db: pool,
+	}
+}
+
+func (lps *LoginThrottlePostgresStorage) AddLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "AddLoginFailure").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	var failures int
+	err := lps.db.QueryRow(ctx,
+		"INSERT INTO login_throttles (key, failures, last_failure_at) "+
+			"VALUES ($1, 1, $2) "+
+			"ON CONFLICT (key) DO UPDATE SET "+
+			"failures = CASE WHEN login_throttles.last_failure_at < $3 THEN 1 ELSE login_throttles.failures + 1 END, "+
+			"last_failure_at = EXCLUDED.last_failure_at "+
+			"RETURNING failures", key, now, now.Add(-window)).
+		Scan(&failures)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (lps *LoginThrottlePostgresStorage) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	logger := log.With().Str("package", "postgres").Str("func", "BlockLogin").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := lps.db.Exec(ctx,
+		"UPDATE login_throttles SET blocked_until = GREATEST(blocked_until, $2) "+
+			"WHERE key = $1", key, until)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
+
+func (lps *LoginThrottlePostgresStorage) GetBlockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "GetBlockedUntil").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	var blockedUntil *time.Time
+	err := lps.db.QueryRow(ctx,
+		"SELECT MAX(blocked_until) FROM login_throttles WHERE key = ANY($1)", keys).
+		Scan(&blockedUntil)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return time.Time{}, err
+	}
+	if blockedUntil == nil {
+		return time.Time{}, nil
+	}
+	return *blockedUntil, nil
+}
+
+func (lps *LoginThrottlePostgresStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "ResetLoginFailures").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := lps.db.Exec(ctx, "DELETE FROM login_throttles WHERE key = $1", key)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
+
+func (lps *LoginThrottlePostgresStorage) AddLoginAttempt(ctx context.Context, attempt *auth.LoginAttempt) error {
+	logger := log.With().Str("package", "postgres").Str("func", "AddLoginAttempt").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := lps.db.Exec(ctx,
+		"INSERT INTO login_attempts "+
+			"(login, ip, reason, attempted_at) "+
+			"VALUES ($1, $2, $3, $4)", attempt.Login, attempt.IP, attempt.Reason, attempt.AttemptedAt)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
+
+func (lps *LoginThrottlePostgresStorage) PurgeLoginAttempts(ctx context.Context, before time.Time) error {
+	logger := log.With().Str("package", "postgres").Str("func", "PurgeLoginAttempts").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	cTag, err := lps.db.Exec(ctx, "DELETE FROM login_attempts WHERE attempted_at < $1", before)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+	logger.Debug().Int64("deleted", cTag.RowsAffected()).Msg("login attempts purged")
+	return nil
+}
+
+func (lps *LoginThrottlePostgresStorage) PurgeLoginThrottles(ctx context.Context, failedBefore time.Time, now time.Time) error {
+	logger := log.With().Str("package", "postgres").Str("func", "PurgeLoginThrottles").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	cTag, err := lps.db.Exec(ctx,
+		"DELETE FROM login_throttles "+
+			"WHERE (last_failure_at < $1) AND (blocked_until IS NULL OR blocked_until <= $2)", failedBefore, now)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+	logger.Debug().Int64("deleted", cTag.RowsAffected()).Msg("login throttles purged")
+	return nil
+}
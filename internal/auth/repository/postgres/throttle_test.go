@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddLoginFailureWindow(t *testing.T) {
+	storage := NewLoginThrottlePostgresStorage(testPool(t))
+	ctx := context.Background()
+	key := fmt.Sprintf("login:window-test-%d", time.Now().UnixNano())
+	now := time.Now().Truncate(time.Microsecond)
+
+	for want := 1; want <= 3; want++ {
+		failures, err := storage.AddLoginFailure(ctx, key, now.Add(time.Duration(want)*time.Minute), time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, want, failures)
+	}
+
+	// the previous failure is older than the window, the count starts over
+	failures, err := storage.AddLoginFailure(ctx, key, now.Add(3*time.Hour), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures)
+
+	require.NoError(t, storage.ResetLoginFailures(ctx, key))
+	failures, err = storage.AddLoginFailure(ctx, key, now.Add(3*time.Hour), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures)
+}
+
+func TestBlockLogin(t *testing.T) {
+	storage := NewLoginThrottlePostgresStorage(testPool(t))
+	ctx := context.Background()
+	run := time.Now().UnixNano()
+	login := fmt.Sprintf("login:block-test-%d", run)
+	ip := fmt.Sprintf("ip:block-test-%d", run)
+	now := time.Now().Truncate(time.Microsecond)
+
+	blockedUntil, err := storage.GetBlockedUntil(ctx, login, ip)
+	require.NoError(t, err)
+	assert.True(t, blockedUntil.IsZero())
+
+	for _, key := range []string{login, ip} {
+		_, err = storage.AddLoginFailure(ctx, key, now, time.Hour)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, storage.BlockLogin(ctx, login, now.Add(time.Hour)))
+	// a shorter block does not shorten the longer one
+	require.NoError(t, storage.BlockLogin(ctx, login, now.Add(time.Minute)))
+	blockedUntil, err = storage.GetBlockedUntil(ctx, login)
+	require.NoError(t, err)
+	assert.True(t, now.Add(time.Hour).Equal(blockedUntil), blockedUntil)
+
+	// the latest block of the keys wins
+	require.NoError(t, storage.BlockLogin(ctx, ip, now.Add(2*time.Hour)))
+	blockedUntil, err = storage.GetBlockedUntil(ctx, login, ip)
+	require.NoError(t, err)
+	assert.True(t, now.Add(2*time.Hour).Equal(blockedUntil), blockedUntil)
+}
+
+func TestPurgeLoginRecords(t *testing.T) {
+	pool := testPool(t)
+	storage := NewLoginThrottlePostgresStorage(pool)
+	ctx := context.Background()
+	run := time.Now().UnixNano()
+	// far in the past, so the records of the other tests are not purged
+	past := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Now()
+
+	oldLogin := fmt.Sprintf("purge-test-old-%d", run)
+	newLogin := fmt.Sprintf("purge-test-new-%d", run)
+	require.NoError(t, storage.AddLoginAttempt(ctx, &auth.LoginAttempt{
+		Login: oldLogin, IP: "127.0.0.1", Reason: auth.LoginFailureBadPassword, AttemptedAt: past,
+	}))
+	require.NoError(t, storage.AddLoginAttempt(ctx, &auth.LoginAttempt{
+		Login: newLogin, IP: "127.0.0.1", Reason: auth.LoginFailureBadPassword, AttemptedAt: now,
+	}))
+
+	require.NoError(t, storage.PurgeLoginAttempts(ctx, past.Add(time.Hour)))
+	attempts := func(login string) int {
+		var count int
+		require.NoError(t, pool.QueryRow(ctx, "SELECT COUNT(*) FROM login_attempts WHERE login = $1", login).Scan(&count))
+		return count
+	}
+	assert.Equal(t, 0, attempts(oldLogin))
+	assert.Equal(t, 1, attempts(newLogin))
+
+	stale := fmt.Sprintf("login:purge-test-stale-%d", run)
+	blocked := fmt.Sprintf("login:purge-test-blocked-%d", run)
+	recent := fmt.Sprintf("login:purge-test-recent-%d", run)
+	for _, key := range []string{stale, blocked} {
+		_, err := storage.AddLoginFailure(ctx, key, past, time.Hour)
+		require.NoError(t, err)
+	}
+	require.NoError(t, storage.BlockLogin(ctx, blocked, now.Add(time.Hour)))
+	_, err := storage.AddLoginFailure(ctx, recent, now, time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, storage.PurgeLoginThrottles(ctx, past.Add(time.Hour), now))
+	throttled := func(key string) bool {
+		var exsist bool
+		require.NoError(t, pool.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM login_throttles WHERE key = $1)", key).Scan(&exsist))
+		return exsist
+	}
+	assert.False(t, throttled(stale))
+	// a block still in force is kept however old the failure is
+	assert.True(t, throttled(blocked))
+	assert.True(t, throttled(recent))
+}
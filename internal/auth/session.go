@@ -0,0 +1,40 @@
+package auth
+
+import "time"
+
+const (
+	AccessTokenCookie  = "Authorization"
+	RefreshTokenCookie = "Refresh-Token"
+)
+
+// Session is a single login of a user. Every refresh rotates the session's
+// refresh token, a rotated token presented again revokes the whole session.
+type Session struct {
+	ID        string
+	UserID    int32
+	CreatedAt time.Time
+	RevokedAt time.Time
+}
+
+func (s *Session) Revoked() bool {
+	return !s.RevokedAt.IsZero()
+}
+
+// RefreshToken is stored by hash only.
+type RefreshToken struct {
+	Hash      string
+	SessionID string
+	ExpiresAt time.Time
+	UsedAt    time.Time
+}
+
+func (rt *RefreshToken) Used() bool {
+	return !rt.UsedAt.IsZero()
+}
+
+type Tokens struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	LoginFailureUnknownUser = "unknown_user"
+	LoginFailureBadPassword = "bad_password"
+)
+
+// LoginAttempt is the audit record of a failed login.
+type LoginAttempt struct {
+	Login       string
+	IP          string
+	Reason      string
+	AttemptedAt time.Time
+}
+
+// TooManyAttemptsError is returned while the login or the client address
+// is blocked after failed attempts.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyAttempts, e.RetryAfter)
+}
+
+func (e *TooManyAttemptsError) Unwrap() error {
+	return ErrTooManyAttempts
+}
@@ -0,0 +1,199 @@
+// Package throttle slows down password guessing. Failed logins are counted
+// per login and per client address; after the free attempts every failure
+// of a login doubles the time until the next attempt is accepted, and both
+// keys are locked out when they reach their threshold.
+package throttle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/rs/zerolog/log"
+)
+
+type Policy struct {
+	// FreeAttempts of a login fail without a delay.
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// IPLockoutThreshold is higher since many users can share an address.
+	IPLockoutThreshold int
+	// Window forgets the failures older than it.
+	Window time.Duration
+	// AttemptsRetention keeps the audit records, zero keeps them forever.
+	AttemptsRetention time.Duration
+}
+
+type Throttler struct {
+	// blocked counts the attempts rejected while blocked, they are not audited
+	blocked int64
+
+	repo   auth.LoginThrottleRepository
+	policy Policy
+	now    func() time.Time
+}
+
+func NewThrottler(repo auth.LoginThrottleRepository, policy Policy) *Throttler {
+	return &Throttler{
+		repo:   repo,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// maxStoredLoginLength caps the logins kept in the keys and the audit
+// records, they come from the client and valid logins are much shorter.
+const maxStoredLoginLength = 256
+
+func storedLogin(login string) string {
+	if len(login) <= maxStoredLoginLength {
+		return login
+	}
+	cut := maxStoredLoginLength
+	for cut > 0 && !utf8.RuneStart(login[cut]) {
+		cut--
+	}
+	return login[:cut]
+}
+
+// loginKey counts all the forms of a login that find the same user together.
+func loginKey(login string) string {
+	return "login:" + storedLogin(auth.NormalizeLogin(login))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a TooManyAttemptsError while the login or the address is blocked.
+func (t *Throttler) Check(ctx context.Context, login string, ip string) error {
+	blockedUntil, err := t.repo.GetBlockedUntil(ctx, loginKey(login), ipKey(ip))
+	if err != nil {
+		return err
+	}
+
+	retryAfter := blockedUntil.Sub(t.now())
+	if retryAfter <= 0 {
+		return nil
+	}
+	// the rejected attempts are cheap to send, so they are only counted
+	atomic.AddInt64(&t.blocked, 1)
+	return &auth.TooManyAttemptsError{RetryAfter: retryAfter}
+}
+
+// Failure records the failed attempt and blocks the keys when needed.
+func (t *Throttler) Failure(ctx context.Context, login string, ip string, reason string) error {
+	logger := log.With().Str("package", "throttle").Str("func", "Failure").Logger()
+
+	now := t.now()
+	err := t.repo.AddLoginAttempt(ctx, &auth.LoginAttempt{
+		Login:       storedLogin(login),
+		IP:          ip,
+		Reason:      reason,
+		AttemptedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	failures, err := t.repo.AddLoginFailure(ctx, loginKey(login), now, t.policy.Window)
+	if err != nil {
+		return err
+	}
+	if until := t.loginBlockedUntil(failures, now); !until.IsZero() {
+		logger.Info().Str("login", login).Int("failures", failures).Time("until", until).Msg("login blocked")
+		if err = t.repo.BlockLogin(ctx, loginKey(login), until); err != nil {
+			return err
+		}
+	}
+
+	if ip == "" {
+		return nil
+	}
+	failures, err = t.repo.AddLoginFailure(ctx, ipKey(ip), now, t.policy.Window)
+	if err != nil {
+		return err
+	}
+	if t.policy.IPLockoutThreshold > 0 && failures >= t.policy.IPLockoutThreshold {
+		until := now.Add(t.policy.LockoutDuration)
+		logger.Warn().Str("ip", ip).Int("failures", failures).Time("until", until).Msg("address locked out")
+		return t.repo.BlockLogin(ctx, ipKey(ip), until)
+	}
+	return nil
+}
+
+// Success forgets the failures of the login, the address keeps its count so
+// a valid account can not be used to reset it.
+func (t *Throttler) Success(ctx context.Context, login string) error {
+	return t.repo.ResetLoginFailures(ctx, loginKey(login))
+}
+
+// Stats is published as expvar metrics.
+func (t *Throttler) Stats() interface{} {
+	return map[string]interface{}{
+		"blocked_attempts": atomic.LoadInt64(&t.blocked),
+	}
+}
+
+// Purge deletes the audit records older than the retention and the counters
+// that neither count failures in the window nor block.
+func (t *Throttler) Purge(ctx context.Context) error {
+	now := t.now()
+	if t.policy.AttemptsRetention > 0 {
+		if err := t.repo.PurgeLoginAttempts(ctx, now.Add(-t.policy.AttemptsRetention)); err != nil {
+			return err
+		}
+	}
+	if t.policy.Window <= 0 {
+		return nil
+	}
+	return t.repo.PurgeLoginThrottles(ctx, now.Add(-t.policy.Window), now)
+}
+
+// StartPurgeWorker runs Purge every interval until ctx is done.
+func (t *Throttler) StartPurgeWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	logger := log.With().Str("package", "throttle").Str("func", "StartPurgeWorker").Logger()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := t.Purge(ctx); err != nil && ctx.Err() == nil {
+				logger.Error().Err(err).Msg("purge login attempts error")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (t *Throttler) loginBlockedUntil(failures int, now time.Time) time.Time {
+	if t.policy.LockoutThreshold > 0 && failures >= t.policy.LockoutThreshold {
+		return now.Add(t.policy.LockoutDuration)
+	}
+	if failures <= t.policy.FreeAttempts || t.policy.BaseDelay <= 0 {
+		return time.Time{}
+	}
+
+	delay := t.policy.BaseDelay
+	for i := t.policy.FreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if t.policy.MaxDelay > 0 && delay >= t.policy.MaxDelay {
+			delay = t.policy.MaxDelay
+			break
+		}
+	}
+	return now.Add(delay)
+}
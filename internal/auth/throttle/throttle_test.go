@@ -0,0 +1,144 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/auth/repository/localstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestThrottler(now *time.Time) *Throttler {
+	throttler := NewThrottler(localstorage.NewLoginThrottleLocalStorage(), Policy{
+		FreeAttempts:       3,
+		BaseDelay:          time.Second,
+		MaxDelay:           8 * time.Second,
+		LockoutThreshold:   10,
+		LockoutDuration:    15 * time.Minute,
+		IPLockoutThreshold: 20,
+		Window:             time.Hour,
+	})
+	throttler.now = func() time.Time { return *now }
+	return throttler
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	var tooManyAttempts *auth.TooManyAttemptsError
+	require.True(t, errors.As(err, &tooManyAttempts), "unexpected error %v", err)
+	assert.ErrorIs(t, err, auth.ErrTooManyAttempts)
+	return tooManyAttempts.RetryAfter
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	throttler := newTestThrottler(&now)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, throttler.Check(ctx, "user", "10.0.0.1"))
+		require.NoError(t, throttler.Failure(ctx, "user", "10.0.0.1", auth.LoginFailureBadPassword))
+	}
+	assert.NoError(t, throttler.Check(ctx, "user", "10.0.0.1"))
+
+	for _, delay := range []time.Duration{1, 2, 4, 8, 8, 8} {
+		require.NoError(t, throttler.Failure(ctx, "user", "10.0.0.1", auth.LoginFailureBadPassword))
+		assert.Equal(t, delay*time.Second, retryAfter(t, throttler.Check(ctx, "User", "10.0.0.2")))
+
+		now = now.Add(delay * time.Second)
+		require.NoError(t, throttler.Check(ctx, "user", "10.0.0.1"))
+	}
+
+	// the 10th failure locks the login out
+	require.NoError(t, throttler.Failure(ctx, "user", "10.0.0.1", auth.LoginFailureBadPassword))
+	assert.Equal(t, 15*time.Minute, retryAfter(t, throttler.Check(ctx, "user", "10.0.0.1")))
+	assert.NoError(t, throttler.Check(ctx, "other", "10.0.0.1"))
+
+	now = now.Add(15 * time.Minute)
+	require.NoError(t, throttler.Check(ctx, "user", "10.0.0.1"))
+	require.NoError(t, throttler.Success(ctx, "user"))
+
+	require.NoError(t, throttler.Failure(ctx, "user", "10.0.0.1", auth.LoginFailureBadPassword))
+	assert.NoError(t, throttler.Check(ctx, "user", "10.0.0.1"))
+}
+
+func TestFailuresExpire(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	throttler := newTestThrottler(&now)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, throttler.Failure(ctx, "user", "10.0.0.1", auth.LoginFailureBadPassword))
+	}
+	now = now.Add(2 * time.Hour)
+
+	require.NoError(t, throttler.Failure(ctx, "user", "10.0.0.1", auth.LoginFailureBadPassword))
+	assert.NoError(t, throttler.Check(ctx, "user", "10.0.0.1"))
+}
+
+func TestAddressLockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	throttler := newTestThrottler(&now)
+
+	for i := 0; i < 20; i++ {
+		login := string(rune('a' + i))
+		require.NoError(t, throttler.Check(ctx, login, "10.0.0.1"))
+		require.NoError(t, throttler.Failure(ctx, login, "10.0.0.1", auth.LoginFailureUnknownUser))
+		// a valid account must not reset the address
+		require.NoError(t, throttler.Success(ctx, "owner"))
+	}
+
+	assert.Equal(t, 15*time.Minute, retryAfter(t, throttler.Check(ctx, "owner", "10.0.0.1")))
+	assert.NoError(t, throttler.Check(ctx, "owner", "10.0.0.2"))
+}
+
+type auditRepository struct {
+	auth.LoginThrottleRepository
+	attempts []*auth.LoginAttempt
+}
+
+func (r *auditRepository) AddLoginAttempt(ctx context.Context, attempt *auth.LoginAttempt) error {
+	r.attempts = append(r.attempts, attempt)
+	return r.LoginThrottleRepository.AddLoginAttempt(ctx, attempt)
+}
+
+func TestBlockedAttemptsAreNotAudited(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	repo := &auditRepository{LoginThrottleRepository: localstorage.NewLoginThrottleLocalStorage()}
+	throttler := NewThrottler(repo, Policy{LockoutThreshold: 1, LockoutDuration: time.Minute, Window: time.Hour})
+	throttler.now = func() time.Time { return now }
+
+	login := strings.Repeat("логин", 100)
+	require.NoError(t, throttler.Failure(ctx, login, "10.0.0.1", auth.LoginFailureUnknownUser))
+	for i := 0; i < 5; i++ {
+		retryAfter(t, throttler.Check(ctx, login, "10.0.0.1"))
+	}
+
+	require.Len(t, repo.attempts, 1)
+	assert.LessOrEqual(t, len(repo.attempts[0].Login), maxStoredLoginLength)
+	assert.True(t, strings.HasPrefix(login, repo.attempts[0].Login))
+	assert.Equal(t, map[string]interface{}{"blocked_attempts": int64(5)}, throttler.Stats())
+}
+
+func TestLoginFormsShareFailures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	throttler := newTestThrottler(&now)
+
+	for _, login := range []string{"alice", "ALICE", "ａｌｉｃｅ", "ａlice"} {
+		require.NoError(t, throttler.Check(ctx, login, "10.0.0.1"))
+		require.NoError(t, throttler.Failure(ctx, login, "10.0.0.1", auth.LoginFailureBadPassword))
+	}
+
+	// the 4th failure is over the free attempts for every form of the login
+	for _, login := range []string{"alice", "Ａｌｉｃｅ", "AlIcE"} {
+		assert.Equal(t, time.Second, retryAfter(t, throttler.Check(ctx, login, "10.0.0.2")))
+	}
+	assert.NoError(t, throttler.Check(ctx, "bob", "10.0.0.2"))
+}
@@ -1,15 +1,21 @@
 package auth
 
-import (
-	"context"
+import "context"
 
-	"github.com/alexkopcak/gophermart/internal/models"
+const (
+	CtxUserKey      = "user"
+	CtxPrincipalKey = "principal"
 )
 
-const CtxUserKey = "user"
-
 type UseCase interface {
 	SignUp(ctx context.Context, userName string, password string) error
-	SignIn(ctx context.Context, userName string, password string) (string, error)
-	ParseToken(ctx context.Context, accessToken string) (*models.User, error)
+	SignIn(ctx context.Context, userName string, password string, clientIP string) (*Tokens, error)
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+	Logout(ctx context.Context, refreshToken string) error
+	LogoutSession(ctx context.Context, userID int32, sessionID string) error
+	LogoutAll(ctx context.Context, userID int32) error
+	ChangePassword(ctx context.Context, userID int32, sessionID string, currentPassword string, newPassword string, clientIP string) error
+	DeleteAccount(ctx context.Context, userID int32, password string, clientIP string) error
+	ParseToken(ctx context.Context, accessToken string) (*Principal, error)
+	JWKS(ctx context.Context) *JSONWebKeySet
 }
@@ -0,0 +1,67 @@
+package usecase
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const hashPrefixLength = 5
+
+// BreachedPasswordList is a local copy of a breached password corpus in the
+// Have I Been Pwned format: one upper case SHA-1 hash per line, optionally
+// followed by ":count". Lookups go through Range, the k-anonymity query of
+// the HIBP API, so the list can be replaced with the remote service.
+type BreachedPasswordList struct {
+	ranges map[string]map[string]struct{}
+}
+
+func LoadBreachedPasswordList(path string) (*BreachedPasswordList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &BreachedPasswordList{ranges: make(map[string]map[string]struct{})}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash := strings.ToUpper(strings.SplitN(text, ":", 2)[0])
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+		}
+		if _, err = hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = make(map[string]struct{})
+		}
+		list.ranges[prefix][suffix] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Range returns the suffixes of the breached hashes starting with prefix.
+func (l *BreachedPasswordList) Range(prefix string) map[string]struct{} {
+	return l.ranges[strings.ToUpper(prefix)]
+}
+
+func (l *BreachedPasswordList) Contains(pwd string) bool {
+	sum := sha1.Sum([]byte(pwd))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, ok := l.Range(hash[:hashPrefixLength])[hash[hashPrefixLength:]]
+	return ok
+}
@@ -3,7 +3,7 @@ package usecase
 import (
 	"context"
 
-	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/alexkopcak/gophermart/internal/auth"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -17,14 +17,57 @@ func (aucm *AuthUseCaseMock) SignUp(ctx context.Context, userName string, passwo
 	return args.Error(0)
 }
 
-func (aucm *AuthUseCaseMock) SignIn(ctx context.Context, userName string, password string) (string, error) {
+func (aucm *AuthUseCaseMock) SignIn(ctx context.Context, userName string, password string, clientIP string) (*auth.Tokens, error) {
 	args := aucm.Called(userName, password)
 
-	return args.Get(0).(string), args.Error(1)
+	return args.Get(0).(*auth.Tokens), args.Error(1)
 }
 
-func (aucm *AuthUseCaseMock) ParseToken(ctx context.Context, accessToken string) (*models.User, error) {
+func (aucm *AuthUseCaseMock) Refresh(ctx context.Context, refreshToken string) (*auth.Tokens, error) {
+	args := aucm.Called(refreshToken)
+
+	return args.Get(0).(*auth.Tokens), args.Error(1)
+}
+
+func (aucm *AuthUseCaseMock) Logout(ctx context.Context, refreshToken string) error {
+	args := aucm.Called(refreshToken)
+
+	return args.Error(0)
+}
+
+func (aucm *AuthUseCaseMock) LogoutSession(ctx context.Context, userID int32, sessionID string) error {
+	args := aucm.Called(userID, sessionID)
+
+	return args.Error(0)
+}
+
+func (aucm *AuthUseCaseMock) LogoutAll(ctx context.Context, userID int32) error {
+	args := aucm.Called(userID)
+
+	return args.Error(0)
+}
+
+func (aucm *AuthUseCaseMock) ChangePassword(ctx context.Context, userID int32, sessionID string,
+	currentPassword string, newPassword string, clientIP string) error {
+	args := aucm.Called(userID, sessionID, currentPassword, newPassword)
+
+	return args.Error(0)
+}
+
+func (aucm *AuthUseCaseMock) DeleteAccount(ctx context.Context, userID int32, password string, clientIP string) error {
+	args := aucm.Called(userID, password)
+
+	return args.Error(0)
+}
+
+func (aucm *AuthUseCaseMock) ParseToken(ctx context.Context, accessToken string) (*auth.Principal, error) {
 	args := aucm.Called(accessToken)
 
-	return args.Get(0).(*models.User), args.Error(1)
+	return args.Get(0).(*auth.Principal), args.Error(1)
+}
+
+func (aucm *AuthUseCaseMock) JWKS(ctx context.Context) *auth.JSONWebKeySet {
+	args := aucm.Called()
+
+	return args.Get(0).(*auth.JSONWebKeySet)
 }
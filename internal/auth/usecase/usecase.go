@@ -2,95 +2,394 @@ package usecase
 
 import (
 	"context"
-	"crypto/sha1"
-	"fmt"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/auth/keys"
+	"github.com/alexkopcak/gophermart/internal/auth/password"
+	"github.com/alexkopcak/gophermart/internal/auth/throttle"
 	"github.com/alexkopcak/gophermart/internal/models"
 	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/rs/zerolog/log"
 )
 
+// claimsVersion 2 replaced the embedded models.User, which carried the
+// password hash, with the user id in the subject.
+const claimsVersion = 2
+
 type AuthClaims struct {
 	jwt.StandardClaims
-	User *models.User
+	Login     string   `json:"login"`
+	SessionID string   `json:"sid,omitempty"`
+	Version   int      `json:"ver"`
+	Roles     []string `json:"roles,omitempty"`
+
+	// User is only read from the tokens issued before the version 2.
+	User *legacyUser `json:"User,omitempty"`
+}
+
+type legacyUser struct {
+	ID       int32  `json:"id"`
+	UserName string `json:"login"`
 }
 
 type AuthUseCase struct {
-	userRepo       auth.UserRepository
-	hashSalt       string
-	signingKey     []byte
-	expireDuration time.Duration
+	userRepo        auth.UserRepository
+	sessionRepo     auth.SessionRepository
+	hasher          *password.Hasher
+	validator       *Validator
+	throttler       *throttle.Throttler
+	keySet          *keys.KeySet
+	expireDuration  time.Duration
+	refreshDuration time.Duration
+	// legacyTokensUntil keeps the tokens of the previous claims version valid
+	// during the rollout, zero time rejects them.
+	legacyTokensUntil time.Time
 }
 
 func NewAuthUseCase(userRepo auth.UserRepository,
-	hashSalt string,
-	signingKey string,
-	tokenTTL int) auth.UseCase {
+	sessionRepo auth.SessionRepository,
+	hasher *password.Hasher,
+	validator *Validator,
+	throttler *throttle.Throttler,
+	keySet *keys.KeySet,
+	tokenTTL int,
+	refreshTokenTTL int,
+	legacyTokensUntil time.Time) auth.UseCase {
 	return &AuthUseCase{
-		userRepo:       userRepo,
-		hashSalt:       hashSalt,
-		signingKey:     []byte(signingKey),
-		expireDuration: time.Duration(tokenTTL) * time.Second,
+		userRepo:        userRepo,
+		sessionRepo:     sessionRepo,
+		hasher:          hasher,
+		validator:       validator,
+		throttler:       throttler,
+		keySet:          keySet,
+		expireDuration:  time.Duration(tokenTTL) * time.Second,
+		refreshDuration: time.Duration(refreshTokenTTL) * time.Second,
+
+		legacyTokensUntil: legacyTokensUntil,
 	}
 }
 
-func generatePasswordHash(password string, hashSalt string) string {
-	pwd := sha1.New()
-	pwd.Write([]byte(password))
-	pwd.Write([]byte(hashSalt))
+func (auc *AuthUseCase) SignUp(ctx context.Context, userName string, pwd string) error {
+	login, err := auc.validator.ValidateSignUp(userName, pwd)
+	if err != nil {
+		return err
+	}
 
-	return fmt.Sprintf("%x", pwd.Sum(nil))
-}
+	hash, err := auc.hasher.Hash(pwd)
+	if err != nil {
+		return err
+	}
 
-func (auc *AuthUseCase) SignUp(ctx context.Context, userName string, password string) error {
 	user := &models.User{
-		UserName: userName,
-		Password: generatePasswordHash(password, auc.hashSalt),
+		UserName: login,
+		Password: hash,
 	}
 	return auc.userRepo.CreateUser(ctx, user)
 }
 
-func (auc *AuthUseCase) SignIn(ctx context.Context, userName string, password string) (string, error) {
-	pwd := generatePasswordHash(password, auc.hashSalt)
-
+// getUser finds the user by the login as stored first, so the logins the
+// migration could not fold are kept apart, and then by the normalized login.
+func (auc *AuthUseCase) getUser(ctx context.Context, userName string) (*models.User, error) {
 	user, err := auc.userRepo.GetUser(ctx, userName)
+	if login := auth.NormalizeLogin(userName); errors.Is(err, auth.ErrUserNotExsist) && login != userName {
+		return auc.userRepo.GetUser(ctx, login)
+	}
+	return user, err
+}
+
+func (auc *AuthUseCase) SignIn(ctx context.Context, userName string, pwd string, clientIP string) (*auth.Tokens, error) {
+	logger := log.With().Str("package", "usecase").Str("func", "SignIn").Logger()
+
+	// a blocked login is refused even with the right password
+	if err := auc.throttler.Check(ctx, userName, clientIP); err != nil {
+		return nil, err
+	}
+
+	user, err := auc.getUser(ctx, userName)
+	if errors.Is(err, auth.ErrUserNotExsist) {
+		// hash anyway, so the response time does not tell whether the login exists
+		_, _ = auc.hasher.Hash(pwd)
+		auc.loginFailed(ctx, userName, clientIP, auth.LoginFailureUnknownUser)
+		return nil, err
+	}
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	ok, needsRehash, err := auc.hasher.Verify(pwd, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		auc.loginFailed(ctx, userName, clientIP, auth.LoginFailureBadPassword)
+		return nil, auth.ErrBadLoginPassword
+	}
+
+	if err = auc.throttler.Success(ctx, userName); err != nil {
+		logger.Error().Err(err).Str("login", userName).Msg("reset login failures error")
+	}
+
+	if needsRehash {
+		// the password is already checked, a failed upgrade must not break the login
+		hash, err := auc.hasher.Hash(pwd)
+		if err == nil {
+			err = auc.userRepo.UpdateUserPassword(ctx, user.ID, hash)
+		}
+		if err != nil {
+			logger.Error().Err(err).Int32("userID", user.ID).Msg("rehash password error")
+		} else {
+			user.Password = hash
+		}
+	}
+
+	now := time.Now()
+	session := &auth.Session{
+		UserID:    user.ID,
+		CreatedAt: now,
+	}
+	session.ID, err = randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	token := &auth.RefreshToken{
+		Hash:      hash,
+		ExpiresAt: now.Add(auc.refreshDuration),
+	}
+	if err = auc.sessionRepo.CreateSession(ctx, session, token); err != nil {
+		return nil, err
+	}
+
+	return auc.newTokens(user, session.ID, refreshToken, token.ExpiresAt, now)
+}
+
+func (auc *AuthUseCase) loginFailed(ctx context.Context, userName string, clientIP string, reason string) {
+	if err := auc.throttler.Failure(ctx, userName, clientIP, reason); err != nil {
+		log.Error().Str("package", "usecase").Str("func", "loginFailed").
+			Err(err).Str("login", userName).Msg("record login failure error")
+	}
+}
+
+// Refresh exchanges the refresh token for a new pair of tokens. Every
+// refresh token is accepted once, a reused one revokes its session.
+func (auc *AuthUseCase) Refresh(ctx context.Context, refreshToken string) (*auth.Tokens, error) {
+	logger := log.With().Str("package", "usecase").Str("func", "Refresh").Logger()
+
+	if refreshToken == "" {
+		return nil, auth.ErrInvalidRefreshToken
+	}
+
+	newToken, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token := &auth.RefreshToken{
+		Hash:      hash,
+		ExpiresAt: now.Add(auc.refreshDuration),
+	}
+	session, err := auc.sessionRepo.RotateRefreshToken(ctx, hashToken(refreshToken), token, now)
+	if errors.Is(err, auth.ErrRefreshTokenReused) {
+		logger.Warn().Msg("refresh token reused, session revoked")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := auc.userRepo.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return auc.newTokens(user, session.ID, newToken, token.ExpiresAt, now)
+}
+
+func (auc *AuthUseCase) Logout(ctx context.Context, refreshToken string) error {
+	if refreshToken == "" {
+		return auth.ErrInvalidRefreshToken
+	}
+	return auc.sessionRepo.RevokeSessionByToken(ctx, hashToken(refreshToken))
+}
+
+// LogoutSession ends the session of an access token, the tokens issued
+// before the sessions have none.
+func (auc *AuthUseCase) LogoutSession(ctx context.Context, userID int32, sessionID string) error {
+	if sessionID == "" {
+		return auth.ErrNoSession
+	}
+	return auc.sessionRepo.RevokeSession(ctx, userID, sessionID)
+}
+
+func (auc *AuthUseCase) LogoutAll(ctx context.Context, userID int32) error {
+	return auc.sessionRepo.RevokeUserSessions(ctx, userID, "")
+}
+
+// ChangePassword replaces the password of the user and revokes every session
+// except the current one, so a stolen session does not survive the change.
+func (auc *AuthUseCase) ChangePassword(ctx context.Context, userID int32, sessionID string,
+	currentPassword string, newPassword string, clientIP string) error {
+	if _, err := auc.checkPassword(ctx, userID, currentPassword, clientIP); err != nil {
+		return err
+	}
+
+	if violations := auc.validator.validatePassword(newPassword); len(violations) > 0 {
+		return &auth.ValidationError{Violations: violations}
+	}
+
+	hash, err := auc.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	if err = auc.userRepo.UpdateUserPassword(ctx, userID, hash); err != nil {
+		return err
+	}
+	return auc.sessionRepo.RevokeUserSessions(ctx, userID, sessionID)
+}
+
+// DeleteAccount anonymises the user and ends all the sessions.
+func (auc *AuthUseCase) DeleteAccount(ctx context.Context, userID int32, pwd string, clientIP string) error {
+	logger := log.With().Str("package", "usecase").Str("func", "DeleteAccount").Logger()
+
+	user, err := auc.checkPassword(ctx, userID, pwd, clientIP)
+	if err != nil {
+		return err
+	}
+
+	if err = auc.userRepo.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+	logger.Info().Int32("userID", userID).Str("login", user.UserName).Msg("account deleted")
+
+	// the account is gone already, so a failed revoke must not fail the request;
+	// the database refuses the orders and withdrawals of a deleted user
+	if err = auc.sessionRepo.RevokeUserSessions(ctx, userID, ""); err != nil {
+		logger.Warn().Err(err).Int32("userID", userID).Msg("revoke sessions of deleted account")
+	}
+	return nil
+}
+
+// checkPassword confirms a sensitive action with the password of the user,
+// the failures count against the login like the failed sign ins.
+func (auc *AuthUseCase) checkPassword(ctx context.Context, userID int32, pwd string, clientIP string) (*models.User, error) {
+	user, err := auc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	if pwd != user.Password {
-		return "", auth.ErrBadLoginPassword
+	if err = auc.throttler.Check(ctx, user.UserName, clientIP); err != nil {
+		return nil, err
 	}
 
+	ok, _, err := auc.hasher.Verify(pwd, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		auc.loginFailed(ctx, user.UserName, clientIP, auth.LoginFailureBadPassword)
+		return nil, auth.ErrBadLoginPassword
+	}
+	return user, nil
+}
+
+func (auc *AuthUseCase) newTokens(user *models.User, sessionID string,
+	refreshToken string, refreshExpiresAt time.Time, now time.Time) (*auth.Tokens, error) {
+	expiresAt := now.Add(auc.expireDuration)
 	claims := AuthClaims{
-		User: user,
+		Login:     user.UserName,
+		SessionID: sessionID,
+		Version:   claimsVersion,
+		Roles:     []string{auth.RoleUser},
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: jwt.At(time.Now().Add(auc.expireDuration)),
+			Subject:   strconv.FormatInt(int64(user.ID), 10),
+			IssuedAt:  jwt.At(now),
+			ExpiresAt: jwt.At(expiresAt),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	accessToken, err := auc.keySet.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.Tokens{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+func randomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// newRefreshToken returns the token for the client and its hash for the storage.
+func newRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
 
-	return token.SignedString(auc.signingKey)
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
 
-func (auc *AuthUseCase) ParseToken(ctx context.Context, accessToken string) (*models.User, error) {
-	token, err := jwt.ParseWithClaims(accessToken, &AuthClaims{},
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, auth.ErrInternalServer
-			}
-			return auc.signingKey, nil
-		})
+func (auc *AuthUseCase) ParseToken(ctx context.Context, accessToken string) (*auth.Principal, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &AuthClaims{}, auc.keySet.Keyfunc)
 
 	if err != nil {
 		return nil, auth.ErrInternalServer
 	}
 
-	if claims, ok := token.Claims.(*AuthClaims); ok && token.Valid {
-		return claims.User, nil
+	claims, ok := token.Claims.(*AuthClaims)
+	if !ok || !token.Valid {
+		return nil, auth.ErrInternalServer
+	}
+
+	if claims.Version == 0 && claims.User != nil && time.Now().Before(auc.legacyTokensUntil) {
+		return &auth.Principal{
+			UserID:    claims.User.ID,
+			Login:     claims.User.UserName,
+			SessionID: claims.SessionID,
+			Roles:     []string{auth.RoleUser},
+		}, nil
 	}
+	if claims.Version != claimsVersion {
+		return nil, auth.ErrInternalServer
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 32)
+	if err != nil {
+		return nil, auth.ErrInternalServer
+	}
+	return &auth.Principal{
+		UserID:    int32(userID),
+		Login:     claims.Login,
+		SessionID: claims.SessionID,
+		Roles:     claims.Roles,
+	}, nil
+}
 
-	return nil, auth.ErrInternalServer
+func (auc *AuthUseCase) JWKS(ctx context.Context) *auth.JSONWebKeySet {
+	return auc.keySet.JWKS()
 }
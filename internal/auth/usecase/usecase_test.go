@@ -2,40 +2,398 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/auth/keys"
+	"github.com/alexkopcak/gophermart/internal/auth/password"
+	"github.com/alexkopcak/gophermart/internal/auth/repository/localstorage"
 	"github.com/alexkopcak/gophermart/internal/auth/repository/mockstorage"
+	"github.com/alexkopcak/gophermart/internal/auth/throttle"
 	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/dgrijalva/jwt-go/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
+func newTestHasher(t *testing.T) *password.Hasher {
+	hasher, err := password.NewHasher(password.Config{
+		Algorithm: password.AlgorithmArgon2id,
+		Argon2: password.Argon2Params{
+			Memory:      1024,
+			Iterations:  1,
+			Parallelism: 1,
+		},
+		LegacySalt: "salt",
+	})
+	require.NoError(t, err)
+	return hasher
+}
+
+func newTestValidator() *Validator {
+	return NewValidator(ValidationPolicy{
+		LoginMinLength:    3,
+		LoginMaxLength:    64,
+		PasswordMinLength: 8,
+		PasswordMaxLength: 128,
+	})
+}
+
+func newTestThrottler() *throttle.Throttler {
+	return throttle.NewThrottler(localstorage.NewLoginThrottleLocalStorage(), throttle.Policy{
+		FreeAttempts:       3,
+		BaseDelay:          time.Second,
+		MaxDelay:           time.Minute,
+		LockoutThreshold:   10,
+		LockoutDuration:    15 * time.Minute,
+		IPLockoutThreshold: 100,
+		Window:             time.Hour,
+	})
+}
+
 func TestAuth(t *testing.T) {
 	repo := new(mockstorage.UserStorageMock)
 
-	uc := NewAuthUseCase(repo, "salt", "secret", 60)
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), newTestHasher(t), newTestValidator(), newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
 
 	username := "user"
-	password := "password"
+	pwd := "password"
 
 	ctx := context.Background()
-	user := &models.User{
-		UserName: username,
-		Password: "c88e9c67041a74e0357befdff93f87dde0904214",
-	}
 
 	// Sign Up
-	repo.On("CreateUser", user).Return(nil)
-	err := uc.SignUp(ctx, username, password)
+	var user *models.User
+	repo.On("CreateUser", mock.MatchedBy(func(u *models.User) bool {
+		user = u
+		return u.UserName == username && strings.HasPrefix(u.Password, "$argon2id$")
+	})).Return(nil)
+	err := uc.SignUp(ctx, username, pwd)
 	assert.NoError(t, err)
+	require.NotNil(t, user)
 
 	// Sign In
 	repo.On("GetUser", user.UserName).Return(user, nil)
-	token, err := uc.SignIn(ctx, username, password)
+	tokens, err := uc.SignIn(ctx, username, pwd, "127.0.0.1")
 	assert.NoError(t, err)
-	assert.NotEmpty(t, token)
+	require.NotNil(t, tokens)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	_, err = uc.SignIn(ctx, username, "wrong password", "127.0.0.1")
+	assert.Equal(t, auth.ErrBadLoginPassword, err)
 
 	// verify token
-	getUser, err := uc.ParseToken(ctx, token)
+	principal, err := uc.ParseToken(ctx, tokens.AccessToken)
+	assert.NoError(t, err)
+	require.NotNil(t, principal)
+	assert.Equal(t, user.ID, principal.UserID)
+	assert.Equal(t, user.UserName, principal.Login)
+	assert.Equal(t, []string{auth.RoleUser}, principal.Roles)
+
+	repo.AssertNotCalled(t, "UpdateUserPassword", mock.Anything, mock.Anything)
+}
+
+func TestSignInRehashesLegacyPassword(t *testing.T) {
+	repo := new(mockstorage.UserStorageMock)
+
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), newTestHasher(t), newTestValidator(), newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+
+	ctx := context.Background()
+	user := &models.User{
+		ID:       1,
+		UserName: "user",
+		// sha1("password" + "salt")
+		Password: "c88e9c67041a74e0357befdff93f87dde0904214",
+	}
+
+	repo.On("GetUser", user.UserName).Return(user, nil)
+	repo.On("UpdateUserPassword", user.ID, mock.MatchedBy(func(hash string) bool {
+		return strings.HasPrefix(hash, "$argon2id$")
+	})).Return(nil).Once()
+
+	_, err := uc.SignIn(ctx, user.UserName, "password", "127.0.0.1")
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+	assert.True(t, strings.HasPrefix(user.Password, "$argon2id$"))
+
+	// the upgraded hash is used from now on
+	_, err = uc.SignIn(ctx, user.UserName, "password", "127.0.0.1")
+	assert.NoError(t, err)
+	repo.AssertNumberOfCalls(t, "UpdateUserPassword", 1)
+}
+
+func TestSignInRehashFailureKeepsLogin(t *testing.T) {
+	repo := new(mockstorage.UserStorageMock)
+
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), newTestHasher(t), newTestValidator(), newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+
+	legacyHash := "c88e9c67041a74e0357befdff93f87dde0904214"
+	user := &models.User{ID: 1, UserName: "user", Password: legacyHash}
+
+	repo.On("GetUser", user.UserName).Return(user, nil)
+	repo.On("UpdateUserPassword", user.ID, mock.Anything).Return(errors.New("database is down"))
+
+	_, err := uc.SignIn(context.Background(), user.UserName, "password", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, legacyHash, user.Password)
+}
+
+func newSignedInUser(t *testing.T) (auth.UseCase, *auth.Tokens) {
+	hasher := newTestHasher(t)
+	hash, err := hasher.Hash("password")
+	require.NoError(t, err)
+
+	repo := new(mockstorage.UserStorageMock)
+	user := &models.User{ID: 1, UserName: "user", Password: hash}
+	repo.On("GetUser", user.UserName).Return(user, nil)
+	repo.On("GetUserByID", user.ID).Return(user, nil)
+
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), hasher, newTestValidator(), newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+	tokens, err := uc.SignIn(context.Background(), user.UserName, "password", "127.0.0.1")
+	require.NoError(t, err)
+	return uc, tokens
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	uc, first := newSignedInUser(t)
+	ctx := context.Background()
+
+	second, err := uc.Refresh(ctx, first.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	principal, err := uc.ParseToken(ctx, second.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), principal.UserID)
+
+	third, err := uc.Refresh(ctx, second.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEmpty(t, third.AccessToken)
+
+	_, err = uc.Refresh(ctx, "unknown token")
+	assert.Equal(t, auth.ErrInvalidRefreshToken, err)
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	uc, first := newSignedInUser(t)
+	ctx := context.Background()
+
+	second, err := uc.Refresh(ctx, first.RefreshToken)
+	require.NoError(t, err)
+
+	// somebody replays the rotated token
+	_, err = uc.Refresh(ctx, first.RefreshToken)
+	assert.Equal(t, auth.ErrRefreshTokenReused, err)
+
+	// the legitimate client is logged out as well
+	_, err = uc.Refresh(ctx, second.RefreshToken)
+	assert.Equal(t, auth.ErrSessionRevoked, err)
+}
+
+func TestLogout(t *testing.T) {
+	uc, tokens := newSignedInUser(t)
+	ctx := context.Background()
+
+	require.NoError(t, uc.Logout(ctx, tokens.RefreshToken))
+	_, err := uc.Refresh(ctx, tokens.RefreshToken)
+	assert.Equal(t, auth.ErrSessionRevoked, err)
+
+	assert.Equal(t, auth.ErrInvalidRefreshToken, uc.Logout(ctx, ""))
+}
+
+func TestLogoutSession(t *testing.T) {
+	uc, first := newSignedInUser(t)
+	ctx := context.Background()
+
+	second, err := uc.SignIn(ctx, "user", "password", "127.0.0.1")
+	require.NoError(t, err)
+	principal, err := uc.ParseToken(ctx, first.AccessToken)
+	require.NoError(t, err)
+
+	require.NoError(t, uc.LogoutSession(ctx, principal.UserID, principal.SessionID))
+	_, err = uc.Refresh(ctx, first.RefreshToken)
+	assert.Equal(t, auth.ErrSessionRevoked, err)
+	_, err = uc.Refresh(ctx, second.RefreshToken)
 	assert.NoError(t, err)
-	assert.Equal(t, user, getUser)
+
+	assert.Equal(t, auth.ErrNoSession, uc.LogoutSession(ctx, principal.UserID, ""))
+}
+
+func TestLogoutAll(t *testing.T) {
+	uc, first := newSignedInUser(t)
+	ctx := context.Background()
+
+	second, err := uc.SignIn(ctx, "user", "password", "127.0.0.1")
+	require.NoError(t, err)
+
+	require.NoError(t, uc.LogoutAll(ctx, 1))
+
+	_, err = uc.Refresh(ctx, first.RefreshToken)
+	assert.Equal(t, auth.ErrSessionRevoked, err)
+	_, err = uc.Refresh(ctx, second.RefreshToken)
+	assert.Equal(t, auth.ErrSessionRevoked, err)
+}
+
+func newLocalUser(t *testing.T) (auth.UseCase, auth.UserRepository, *auth.Tokens) {
+	repo := localstorage.NewUserLocalStorage()
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), newTestHasher(t), newTestValidator(),
+		newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+	ctx := context.Background()
+
+	require.NoError(t, uc.SignUp(ctx, "user", "password"))
+	tokens, err := uc.SignIn(ctx, "user", "password", "127.0.0.1")
+	require.NoError(t, err)
+	return uc, repo, tokens
+}
+
+func TestChangePassword(t *testing.T) {
+	uc, _, current := newLocalUser(t)
+	ctx := context.Background()
+
+	other, err := uc.SignIn(ctx, "user", "password", "127.0.0.2")
+	require.NoError(t, err)
+	principal, err := uc.ParseToken(ctx, current.AccessToken)
+	require.NoError(t, err)
+
+	err = uc.ChangePassword(ctx, principal.UserID, principal.SessionID, "wrong password", "new password", "127.0.0.1")
+	assert.Equal(t, auth.ErrBadLoginPassword, err)
+
+	err = uc.ChangePassword(ctx, principal.UserID, principal.SessionID, "password", "short", "127.0.0.1")
+	assert.ErrorIs(t, err, auth.ErrValidation)
+
+	require.NoError(t, uc.ChangePassword(ctx, principal.UserID, principal.SessionID, "password", "new password", "127.0.0.1"))
+
+	_, err = uc.Refresh(ctx, other.RefreshToken)
+	assert.Equal(t, auth.ErrSessionRevoked, err)
+	_, err = uc.Refresh(ctx, current.RefreshToken)
+	assert.NoError(t, err)
+
+	_, err = uc.SignIn(ctx, "user", "password", "127.0.0.1")
+	assert.Equal(t, auth.ErrBadLoginPassword, err)
+	_, err = uc.SignIn(ctx, "user", "new password", "127.0.0.1")
+	assert.NoError(t, err)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	uc, repo, tokens := newLocalUser(t)
+	ctx := context.Background()
+
+	principal, err := uc.ParseToken(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+
+	assert.Equal(t, auth.ErrBadLoginPassword, uc.DeleteAccount(ctx, principal.UserID, "wrong password", "127.0.0.1"))
+	require.NoError(t, uc.DeleteAccount(ctx, principal.UserID, "password", "127.0.0.1"))
+
+	_, err = repo.GetUserByID(ctx, principal.UserID)
+	assert.Equal(t, auth.ErrUserNotExsist, err)
+	_, err = uc.Refresh(ctx, tokens.RefreshToken)
+	assert.Equal(t, auth.ErrSessionRevoked, err)
+	_, err = uc.SignIn(ctx, "user", "password", "127.0.0.1")
+	assert.Equal(t, auth.ErrUserNotExsist, err)
+}
+
+type failingRevokeRepository struct {
+	auth.SessionRepository
+}
+
+func (failingRevokeRepository) RevokeUserSessions(context.Context, int32, string) error {
+	return errors.New("connection reset")
+}
+
+func TestDeleteAccountRevokeFailure(t *testing.T) {
+	repo := localstorage.NewUserLocalStorage()
+	uc := NewAuthUseCase(repo, failingRevokeRepository{localstorage.NewSessionLocalStorage()}, newTestHasher(t),
+		newTestValidator(), newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+	ctx := context.Background()
+
+	require.NoError(t, uc.SignUp(ctx, "user", "password"))
+	tokens, err := uc.SignIn(ctx, "user", "password", "127.0.0.1")
+	require.NoError(t, err)
+	principal, err := uc.ParseToken(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+
+	// the account is deleted, so the request succeeds
+	require.NoError(t, uc.DeleteAccount(ctx, principal.UserID, "password", "127.0.0.1"))
+	_, err = repo.GetUserByID(ctx, principal.UserID)
+	assert.Equal(t, auth.ErrUserNotExsist, err)
+}
+
+func TestAccessTokenClaims(t *testing.T) {
+	uc, tokens := newSignedInUser(t)
+
+	token, _, err := new(jwt.Parser).ParseUnverified(tokens.AccessToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	claims := token.Claims.(jwt.MapClaims)
+	assert.Equal(t, "1", claims["sub"])
+	assert.Equal(t, "user", claims["login"])
+	assert.EqualValues(t, claimsVersion, claims["ver"])
+	assert.NotEmpty(t, claims["sid"])
+	assert.NotContains(t, claims, "User")
+
+	principal, err := uc.ParseToken(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, claims["sid"], principal.SessionID)
+}
+
+func TestLegacyAccessToken(t *testing.T) {
+	keySet := keys.NewHMACKeySet([]byte("secret"))
+	legacy, err := keySet.Sign(struct {
+		jwt.StandardClaims
+		User *models.User
+	}{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: jwt.At(time.Now().Add(time.Minute))},
+		User:           &models.User{ID: 7, UserName: "user", Password: "hash"},
+	})
+	require.NoError(t, err)
+
+	uc := NewAuthUseCase(nil, nil, newTestHasher(t), newTestValidator(), newTestThrottler(), keySet, 60, 3600, time.Now().Add(time.Hour))
+	principal, err := uc.ParseToken(context.Background(), legacy)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), principal.UserID)
+	assert.Equal(t, "user", principal.Login)
+
+	// the rollout window is over
+	uc = NewAuthUseCase(nil, nil, newTestHasher(t), newTestValidator(), newTestThrottler(), keySet, 60, 3600, time.Now().Add(-time.Second))
+	_, err = uc.ParseToken(context.Background(), legacy)
+	assert.Error(t, err)
+
+	uc = NewAuthUseCase(nil, nil, newTestHasher(t), newTestValidator(), newTestThrottler(), keySet, 60, 3600, time.Time{})
+	_, err = uc.ParseToken(context.Background(), legacy)
+	assert.Error(t, err)
+}
+
+func TestSignInLockout(t *testing.T) {
+	hasher := newTestHasher(t)
+	hash, err := hasher.Hash("password")
+	require.NoError(t, err)
+
+	repo := new(mockstorage.UserStorageMock)
+	user := &models.User{ID: 1, UserName: "user", Password: hash}
+	repo.On("GetUser", user.UserName).Return(user, nil)
+	repo.On("GetUser", "nobody").Return((*models.User)(nil), auth.ErrUserNotExsist)
+
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), hasher, newTestValidator(), newTestThrottler(),
+		keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+	ctx := context.Background()
+
+	// unknown logins are counted the same way
+	for i := 0; i < 3; i++ {
+		_, err = uc.SignIn(ctx, "nobody", "password", "10.0.0.1")
+		assert.Equal(t, auth.ErrUserNotExsist, err)
+	}
+	_, err = uc.SignIn(ctx, "nobody", "password", "10.0.0.1")
+	assert.Equal(t, auth.ErrUserNotExsist, err)
+	_, err = uc.SignIn(ctx, "nobody", "password", "10.0.0.1")
+	assert.ErrorIs(t, err, auth.ErrTooManyAttempts)
+
+	for i := 0; i < 4; i++ {
+		_, err = uc.SignIn(ctx, user.UserName, "wrong password", "10.0.0.2")
+		assert.Equal(t, auth.ErrBadLoginPassword, err)
+	}
+	_, err = uc.SignIn(ctx, user.UserName, "password", "10.0.0.3")
+	assert.ErrorIs(t, err, auth.ErrTooManyAttempts)
 }
@@ -0,0 +1,113 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+)
+
+// loginSymbols are allowed in logins besides letters and digits.
+const loginSymbols = "._-@"
+
+type ValidationPolicy struct {
+	LoginMinLength    int
+	LoginMaxLength    int
+	PasswordMinLength int
+	PasswordMaxLength int
+	// PasswordMinCharClasses of lower case, upper case, digits and other symbols.
+	PasswordMinCharClasses int
+	// BreachedPasswords is optional.
+	BreachedPasswords *BreachedPasswordList
+}
+
+type Validator struct {
+	policy ValidationPolicy
+}
+
+func NewValidator(policy ValidationPolicy) *Validator {
+	return &Validator{policy: policy}
+}
+
+// ValidateSignUp returns the normalized login or a ValidationError with all
+// the broken rules.
+func (v *Validator) ValidateSignUp(login string, pwd string) (string, error) {
+	login = auth.NormalizeLogin(login)
+
+	violations := append(v.validateLogin(login), v.validatePassword(pwd)...)
+	if len(violations) > 0 {
+		return "", &auth.ValidationError{Violations: violations}
+	}
+	return login, nil
+}
+
+func (v *Validator) validateLogin(login string) []auth.Violation {
+	if login == "" {
+		return []auth.Violation{{Field: auth.FieldLogin, Rule: auth.RuleRequired, Message: "логин обязателен"}}
+	}
+
+	var violations []auth.Violation
+	length := utf8.RuneCountInString(login)
+	if length < v.policy.LoginMinLength {
+		violations = append(violations, auth.Violation{Field: auth.FieldLogin, Rule: auth.RuleMinLength,
+			Message: fmt.Sprintf("логин короче %d символов", v.policy.LoginMinLength)})
+	}
+	if v.policy.LoginMaxLength > 0 && length > v.policy.LoginMaxLength {
+		violations = append(violations, auth.Violation{Field: auth.FieldLogin, Rule: auth.RuleMaxLength,
+			Message: fmt.Sprintf("логин длиннее %d символов", v.policy.LoginMaxLength)})
+	}
+	for _, r := range login {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(loginSymbols, r) {
+			violations = append(violations, auth.Violation{Field: auth.FieldLogin, Rule: auth.RuleCharset,
+				Message: "логин может содержать только буквы, цифры и символы " + loginSymbols})
+			break
+		}
+	}
+	return violations
+}
+
+func (v *Validator) validatePassword(pwd string) []auth.Violation {
+	if pwd == "" {
+		return []auth.Violation{{Field: auth.FieldPassword, Rule: auth.RuleRequired, Message: "пароль обязателен"}}
+	}
+
+	var violations []auth.Violation
+	length := utf8.RuneCountInString(pwd)
+	if length < v.policy.PasswordMinLength {
+		violations = append(violations, auth.Violation{Field: auth.FieldPassword, Rule: auth.RuleMinLength,
+			Message: fmt.Sprintf("пароль короче %d символов", v.policy.PasswordMinLength)})
+	}
+	if v.policy.PasswordMaxLength > 0 && length > v.policy.PasswordMaxLength {
+		violations = append(violations, auth.Violation{Field: auth.FieldPassword, Rule: auth.RuleMaxLength,
+			Message: fmt.Sprintf("пароль длиннее %d символов", v.policy.PasswordMaxLength)})
+	}
+	if charClasses(pwd) < v.policy.PasswordMinCharClasses {
+		violations = append(violations, auth.Violation{Field: auth.FieldPassword, Rule: auth.RuleCharClasses,
+			Message: fmt.Sprintf("пароль должен содержать символы хотя бы %d типов: "+
+				"строчные буквы, заглавные буквы, цифры, прочие символы", v.policy.PasswordMinCharClasses)})
+	}
+	if v.policy.BreachedPasswords != nil && v.policy.BreachedPasswords.Contains(pwd) {
+		violations = append(violations, auth.Violation{Field: auth.FieldPassword, Rule: auth.RuleBreached,
+			Message: "пароль найден в утечках, выберите другой"})
+	}
+	return violations
+}
+
+func charClasses(pwd string) int {
+	var lower, upper, digit, other int
+	for _, r := range pwd {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
@@ -0,0 +1,155 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/auth/keys"
+	"github.com/alexkopcak/gophermart/internal/auth/repository/localstorage"
+	"github.com/alexkopcak/gophermart/internal/auth/repository/mockstorage"
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func writeBreachedList(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// sha1("password1"), sha1("qwerty123")
+	data := "# breached\n" +
+		"E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D:2413945\n" +
+		"5cec175b165e3d5e62c9e13ce848ef6feac81bff\n"
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func rules(err error) []string {
+	var validationError *auth.ValidationError
+	if !errors.As(err, &validationError) {
+		return nil
+	}
+	result := make([]string, 0, len(validationError.Violations))
+	for _, violation := range validationError.Violations {
+		result = append(result, violation.Field+" "+violation.Rule)
+	}
+	return result
+}
+
+func TestValidateSignUp(t *testing.T) {
+	breached, err := LoadBreachedPasswordList(writeBreachedList(t))
+	require.NoError(t, err)
+
+	validator := NewValidator(ValidationPolicy{
+		LoginMinLength:         3,
+		LoginMaxLength:         12,
+		PasswordMinLength:      8,
+		PasswordMaxLength:      20,
+		PasswordMinCharClasses: 2,
+		BreachedPasswords:      breached,
+	})
+
+	tt := []struct {
+		name     string
+		login    string
+		password string
+		want     string
+		rules    []string
+	}{
+		{name: "valid", login: "Ivan.Petrov", password: "correct horse", want: "ivan.petrov"},
+		{name: "compatibility form", login: "ＩＶＡＮ_1", password: "correct horse", want: "ivan_1"},
+		{name: "cyrillic", login: "Иван", password: "correct horse", want: "иван"},
+		{name: "empty", rules: []string{"login required", "password required"}},
+		{name: "short", login: "iv", password: "a", rules: []string{"login min_length", "password min_length", "password char_classes"}},
+		{name: "long", login: "ivan.petrov.ivanovich", password: "correct horse battery staple",
+			rules: []string{"login max_length", "password max_length"}},
+		{name: "whitespace", login: "ivan petrov", password: "correct horse", rules: []string{"login charset"}},
+		{name: "control", login: "ivan\x00", password: "correct horse", rules: []string{"login charset"}},
+		{name: "breached", login: "ivan", password: "password1", rules: []string{"password breached"}},
+		{name: "breached lower case list", login: "ivan", password: "qwerty123", rules: []string{"password breached"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			login, err := validator.ValidateSignUp(tc.login, tc.password)
+			if tc.rules == nil {
+				require.NoError(t, err)
+				assert.Equal(t, tc.want, login)
+				return
+			}
+			assert.ErrorIs(t, err, auth.ErrValidation)
+			assert.Equal(t, tc.rules, rules(err))
+		})
+	}
+}
+
+func TestLoadBreachedPasswordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("not a hash\n"), 0600))
+
+	_, err := LoadBreachedPasswordList(path)
+	assert.Error(t, err)
+
+	list, err := LoadBreachedPasswordList(writeBreachedList(t))
+	require.NoError(t, err)
+	assert.Len(t, list.Range("e38ad"), 1)
+	assert.Empty(t, list.Range("00000"))
+	assert.False(t, list.Contains("correct horse"))
+}
+
+func TestSignUpNormalizesLogin(t *testing.T) {
+	repo := localstorage.NewUserLocalStorage()
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), newTestHasher(t), newTestValidator(),
+		newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+	ctx := context.Background()
+
+	require.NoError(t, uc.SignUp(ctx, "Ivan", "password"))
+	assert.Equal(t, auth.ErrUserAlreadyExsist, uc.SignUp(ctx, "IVAN", "password"))
+
+	user, err := repo.GetUser(ctx, "ivan")
+	require.NoError(t, err)
+	assert.Equal(t, "ivan", user.UserName)
+
+	err = uc.SignUp(ctx, "ivan petrov", "")
+	assert.Equal(t, []string{"login charset", "password required"}, rules(err))
+}
+
+func TestSignInLegacyLogin(t *testing.T) {
+	hasher := newTestHasher(t)
+	hash, err := hasher.Hash("password")
+	require.NoError(t, err)
+
+	repo := new(mockstorage.UserStorageMock)
+	user := &models.User{ID: 1, UserName: "Ivan", Password: hash}
+	repo.On("GetUser", "Ivan").Return(user, nil)
+
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), hasher, newTestValidator(),
+		newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+	_, err = uc.SignIn(context.Background(), "Ivan", "password", "127.0.0.1")
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+	repo.AssertNotCalled(t, "UpdateUserPassword", mock.Anything, mock.Anything)
+}
+
+func TestSignUpLoginCollision(t *testing.T) {
+	repo := localstorage.NewUserLocalStorage()
+	uc := NewAuthUseCase(repo, localstorage.NewSessionLocalStorage(), newTestHasher(t), newTestValidator(),
+		newTestThrottler(), keys.NewHMACKeySet([]byte("secret")), 60, 3600, time.Time{})
+	ctx := context.Background()
+
+	hasher := newTestHasher(t)
+	hash, err := hasher.Hash("password")
+	require.NoError(t, err)
+	// registered before the normalization
+	require.NoError(t, repo.CreateUser(ctx, &models.User{UserName: "Bob", Password: hash}))
+
+	assert.Equal(t, auth.ErrUserAlreadyExsist, uc.SignUp(ctx, "bob", "other password"))
+	assert.Equal(t, auth.ErrUserAlreadyExsist, uc.SignUp(ctx, "ｂｏｂ", "other password"))
+
+	_, err = uc.SignIn(ctx, "Bob", "password", "127.0.0.1")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	FieldLogin    = "login"
+	FieldPassword = "password"
+
+	RuleRequired    = "required"
+	RuleMinLength   = "min_length"
+	RuleMaxLength   = "max_length"
+	RuleCharset     = "charset"
+	RuleCharClasses = "char_classes"
+	RuleBreached    = "breached"
+)
+
+// NormalizeLogin folds the case and the compatibility forms of the login,
+// so "Bob" and "ｂｏｂ" are the same user.
+func NormalizeLogin(login string) string {
+	return cases.Fold().String(norm.NFKC.String(login))
+}
+
+// Violation is a broken validation rule, Message is shown to the user.
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	rules := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		rules = append(rules, violation.Field+" "+violation.Rule)
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(rules, ", "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+type PoolConfig struct {
+	DataBaseURI       string
+	MinConns          int32
+	MaxConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	StatementTimeout  time.Duration
+}
+
+// Stats is a snapshot of the pool usage published as expvar metrics.
+type Stats struct {
+	TotalConns           int32         `json:"total_conns"`
+	IdleConns            int32         `json:"idle_conns"`
+	AcquiredConns        int32         `json:"acquired_conns"`
+	ConstructingConns    int32         `json:"constructing_conns"`
+	MaxConns             int32         `json:"max_conns"`
+	AcquireCount         int64         `json:"acquire_count"`
+	EmptyAcquireCount    int64         `json:"empty_acquire_count"`
+	CanceledAcquireCount int64         `json:"canceled_acquire_count"`
+	AcquireDuration      time.Duration `json:"acquire_duration_ns"`
+}
+
+// NewPool opens the connection pool shared by all postgres storages.
+// Zero values of the config keep the pgxpool defaults.
+func NewPool(ctx context.Context, cfg PoolConfig) (*pgxpool.Pool, error) {
+	logger := log.With().Str("package", "database").Str("func", "NewPool").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	poolConfig, err := pgxpool.ParseConfig(cfg.DataBaseURI)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+	if cfg.StatementTimeout > 0 {
+		poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+
+	pool, err := pgxpool.ConnectConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug().Int32("minConns", poolConfig.MinConns).Int32("maxConns", poolConfig.MaxConns).Msg("pool created")
+	return pool, nil
+}
+
+func PoolStats(pool *pgxpool.Pool) Stats {
+	stat := pool.Stat()
+	return Stats{
+		TotalConns:           stat.TotalConns(),
+		IdleConns:            stat.IdleConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		MaxConns:             stat.MaxConns(),
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireDuration:      stat.AcquireDuration(),
+	}
+}
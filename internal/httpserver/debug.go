@@ -0,0 +1,14 @@
+package httpserver
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// NewDebugHandler serves the expvar metrics. The dump includes the command
+// line and the runtime stats, so it is served on an internal listener only.
+func NewDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
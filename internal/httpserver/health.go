@@ -0,0 +1,39 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HealthStatusOK       = "ok"
+	HealthStatusDegraded = "degraded"
+)
+
+// HealthCheck reports the state of a dependency and whether it is usable.
+type HealthCheck func() (state string, healthy bool)
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// healthHandler always answers 200 while the server is up, a broken
+// dependency only degrades the service.
+func healthHandler(checks map[string]HealthCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := healthResponse{
+			Status: HealthStatusOK,
+			Checks: make(map[string]string, len(checks)),
+		}
+		for name, check := range checks {
+			state, healthy := check()
+			response.Checks[name] = state
+			if !healthy {
+				response.Status = HealthStatusDegraded
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -1,28 +1,48 @@
 package httpserver
 
 import (
-	"sync"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
 	authhandlers "github.com/alexkopcak/gophermart/internal/auth/handlers"
+	"github.com/alexkopcak/gophermart/internal/idempotency"
+	idempotencyhandlers "github.com/alexkopcak/gophermart/internal/idempotency/handlers"
 	"github.com/alexkopcak/gophermart/internal/order"
 	orderhandlers "github.com/alexkopcak/gophermart/internal/order/handlers"
 	"github.com/gin-contrib/gzip"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 )
 
-func NewGinEngine(wg *sync.WaitGroup, uChannel chan *string, auc auth.UseCase, ouc order.UseCase, asaddress string) *gin.Engine {
+func NewGinEngine(auc auth.UseCase, ouc order.UseCase, idempotencyRepo idempotency.Repository, idempotencyKeyTTL time.Duration,
+	tokenSources []authhandlers.TokenSource, trustedProxies []string, healthChecks map[string]HealthCheck) *gin.Engine {
 	router := gin.Default()
+	// the client address counts failed logins, it must not be taken from any X-Forwarded-For
+	proxies := make([]string, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		if proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Error().Err(err).Msg("set trusted proxies error, no proxy is trusted")
+		_ = router.SetTrustedProxies(nil)
+	}
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 
 	router.Use(gzipMiddlewareHandle)
 	router.Use(gzip.Gzip(gzip.BestSpeed, gzip.WithDecompressFn(gzip.DefaultDecompressHandle)))
 
-	authhandlers.RegisterHTTPEndpoints(router, auc)
+	router.GET("/api/health", healthHandler(healthChecks))
 
-	orderhandlers.RegisterHTTPEndpoints(wg, uChannel, router, authhandlers.AuthMiddlewareHandle(auc), ouc, asaddress)
+	authMiddleware := authhandlers.AuthMiddlewareHandle(auc, tokenSources...)
+
+	authhandlers.RegisterHTTPEndpoints(router, authMiddleware, auc)
+
+	orderhandlers.RegisterHTTPEndpoints(router, authMiddleware,
+		idempotencyhandlers.IdempotencyMiddlewareHandle(idempotencyRepo, idempotencyKeyTTL), ouc)
 
 	return router
 }
@@ -0,0 +1,9 @@
+package idempotency
+
+import "errors"
+
+var (
+	ErrKeyInProgress = errors.New("request with this idempotency key is still in progress")
+	ErrKeyReused     = errors.New("idempotency key was used with another request")
+	ErrKeyTooLong    = errors.New("idempotency key is too long")
+)
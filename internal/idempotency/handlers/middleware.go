@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/idempotency"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddlewareHandle replays the stored response when a request is
+// retried with the same Idempotency-Key header. It must run after the auth
+// middleware, keys are scoped by user.
+func IdempotencyMiddlewareHandle(repo idempotency.Repository, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := log.With().Str("package", "handlers").Str("func", "IdempotencyMiddlewareHandle").Logger()
+
+		logger.Debug().Msg("enter")
+		defer logger.Debug().Msg("exit")
+
+		key := c.GetHeader(idempotency.HeaderKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > idempotency.MaxKeyLength {
+			logger.Debug().Err(idempotency.ErrKeyTooLong).Msg("exit with error")
+			c.String(http.StatusBadRequest, "неверный формат запроса")
+			c.Abort()
+			return
+		}
+
+		user, _ := c.Get(auth.CtxUserKey)
+		userID, ok := user.(int32)
+		if !ok {
+			logger.Debug().Msg("exit with error: user not found")
+			c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+			c.Abort()
+			return
+		}
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			logger.Debug().Err(err).Msg("exit with error")
+			c.String(http.StatusBadRequest, "неверный формат запроса")
+			c.Abort()
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.Sum256(body)
+		record := &idempotency.Record{
+			UserID:      userID,
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			RequestHash: hex.EncodeToString(hash[:]),
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+
+		stored, err := repo.Reserve(c.Request.Context(), record)
+		if err != nil {
+			logger.Debug().Err(err).Msg("exit with error")
+			c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
+			c.Abort()
+			return
+		}
+
+		if stored != nil {
+			if !stored.Matches(record) {
+				logger.Debug().Err(idempotency.ErrKeyReused).Str("key", key).Msg("exit with error")
+				c.String(http.StatusUnprocessableEntity, "ключ идемпотентности уже использован для другого запроса")
+				c.Abort()
+				return
+			}
+			if !stored.Completed() {
+				logger.Debug().Err(idempotency.ErrKeyInProgress).Str("key", key).Msg("exit with error")
+				c.String(http.StatusConflict, "запрос с этим ключом идемпотентности ещё обрабатывается")
+				c.Abort()
+				return
+			}
+
+			logger.Debug().Str("key", key).Int("status", stored.StatusCode).Msg("replay stored response")
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.StatusCode, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		// the key is released even if the client has gone, otherwise the
+		// retries get 409 until the key expires
+		release := func() {
+			if err := repo.Release(context.Background(), userID, key); err != nil {
+				logger.Debug().Err(err).Msg("release key error")
+			}
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				release()
+				panic(r)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer, body: new(bytes.Buffer)}
+		c.Writer = recorder
+
+		c.Next()
+
+		// server errors are not stored, so the client may retry them
+		if recorder.Status() >= http.StatusInternalServerError {
+			release()
+			return
+		}
+
+		record.StatusCode = recorder.Status()
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+		if err := repo.Complete(context.Background(), record); err != nil {
+			logger.Debug().Err(err).Msg("complete key error")
+			release()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	"github.com/alexkopcak/gophermart/internal/idempotency"
+	"github.com/alexkopcak/gophermart/internal/idempotency/repository/localstorage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddlewareHandle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	calls := 0
+	router.Use(func(c *gin.Context) {
+		c.Set(auth.CtxUserKey, int32(1))
+	})
+	router.POST("/api/user/orders",
+		IdempotencyMiddlewareHandle(localstorage.NewIdempotencyLocalStorage(), time.Hour),
+		func(c *gin.Context) {
+			calls++
+			if calls > 1 {
+				c.String(http.StatusOK, "номер заказа уже был загружен этим пользователем")
+				return
+			}
+			c.String(http.StatusAccepted, "новый номер заказа принят в обработку")
+		})
+
+	send := func(key string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		if key != "" {
+			req.Header.Set(idempotency.HeaderKey, key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("key-1", "12345678903")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, 1, calls)
+
+	// retry returns the original response without calling the handler
+	w = send("key-1", "12345678903")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "новый номер заказа принят в обработку", w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 1, calls)
+
+	// the same key with another body is rejected
+	w = send("key-1", "9278923470")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 1, calls)
+
+	// requests without a key are not deduplicated
+	w = send("", "12345678903")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, calls)
+}
+
+type failingCompleteRepository struct {
+	idempotency.Repository
+}
+
+func (r *failingCompleteRepository) Complete(ctx context.Context, record *idempotency.Record) error {
+	return errors.New("complete error")
+}
+
+func TestIdempotencyMiddlewareReleasesKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	calls := 0
+	newRouter := func(repo idempotency.Repository, panics bool) *gin.Engine {
+		router := gin.New()
+		router.Use(gin.CustomRecovery(func(c *gin.Context, err interface{}) {
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}))
+		router.Use(func(c *gin.Context) {
+			c.Set(auth.CtxUserKey, int32(1))
+		})
+		router.POST("/api/user/orders", IdempotencyMiddlewareHandle(repo, time.Hour), func(c *gin.Context) {
+			calls++
+			if panics {
+				panic("handler panic")
+			}
+			c.String(http.StatusAccepted, "новый номер заказа принят в обработку")
+		})
+		return router
+	}
+	send := func(router *gin.Engine) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("12345678903"))
+		req.Header.Set(idempotency.HeaderKey, "key-1")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	repo := localstorage.NewIdempotencyLocalStorage()
+	assert.Equal(t, http.StatusInternalServerError, send(newRouter(repo, true)).Code)
+	assert.Equal(t, http.StatusAccepted, send(newRouter(repo, false)).Code)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	router := newRouter(&failingCompleteRepository{Repository: localstorage.NewIdempotencyLocalStorage()}, false)
+	assert.Equal(t, http.StatusAccepted, send(router).Code)
+	assert.Equal(t, http.StatusAccepted, send(router).Code)
+	assert.Equal(t, 2, calls)
+}
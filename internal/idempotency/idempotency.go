@@ -0,0 +1,31 @@
+package idempotency
+
+import "time"
+
+const HeaderKey = "Idempotency-Key"
+
+const MaxKeyLength = 255
+
+// Record keeps the response of the first request made with the key.
+// StatusCode is zero while that request is still being handled.
+type Record struct {
+	UserID      int32
+	Key         string
+	Method      string
+	Path        string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+func (r *Record) Completed() bool {
+	return r.StatusCode != 0
+}
+
+func (r *Record) Matches(other *Record) bool {
+	return r.Method == other.Method &&
+		r.Path == other.Path &&
+		r.RequestHash == other.RequestHash
+}
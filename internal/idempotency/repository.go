@@ -0,0 +1,11 @@
+package idempotency
+
+import "context"
+
+type Repository interface {
+	// Reserve stores the record unless a live record with the same key
+	// exists, in which case the stored record is returned.
+	Reserve(ctx context.Context, record *Record) (*Record, error)
+	Complete(ctx context.Context, record *Record) error
+	Release(ctx context.Context, userID int32, key string) error
+}
@@ -0,0 +1,58 @@
+package localstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/idempotency"
+)
+
+type recordKey struct {
+	userID int32
+	key    string
+}
+
+type IdempotencyLocalStorage struct {
+	records map[recordKey]*idempotency.Record
+	mutex   *sync.Mutex
+}
+
+func NewIdempotencyLocalStorage() idempotency.Repository {
+	return &IdempotencyLocalStorage{
+		records: make(map[recordKey]*idempotency.Record),
+		mutex:   new(sync.Mutex),
+	}
+}
+
+func (ils *IdempotencyLocalStorage) Reserve(ctx context.Context, record *idempotency.Record) (*idempotency.Record, error) {
+	ils.mutex.Lock()
+	defer ils.mutex.Unlock()
+
+	id := recordKey{userID: record.UserID, key: record.Key}
+	if stored, exsist := ils.records[id]; exsist && stored.ExpiresAt.After(time.Now()) {
+		result := *stored
+		return &result, nil
+	}
+
+	stored := *record
+	ils.records[id] = &stored
+	return nil, nil
+}
+
+func (ils *IdempotencyLocalStorage) Complete(ctx context.Context, record *idempotency.Record) error {
+	ils.mutex.Lock()
+	defer ils.mutex.Unlock()
+
+	stored := *record
+	ils.records[recordKey{userID: record.UserID, key: record.Key}] = &stored
+	return nil
+}
+
+func (ils *IdempotencyLocalStorage) Release(ctx context.Context, userID int32, key string) error {
+	ils.mutex.Lock()
+	defer ils.mutex.Unlock()
+
+	delete(ils.records, recordKey{userID: userID, key: key})
+	return nil
+}
@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexkopcak/gophermart/internal/idempotency"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+type IdempotencyPostgresStorage struct {
+	db *pgxpool.Pool
+}
+
+func NewIdempotencyPostgresStorage(pool *pgxpool.Pool) idempotency.Repository {
+	return &IdempotencyPostgresStorage{
+		db: pool,
+	}
+}
+
+func (ips *IdempotencyPostgresStorage) Reserve(ctx context.Context, record *idempotency.Record) (*idempotency.Record, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "Reserve").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := ips.db.Exec(ctx,
+		"DELETE FROM idempotency_keys "+
+			"WHERE (user_id = $1) AND (expires_at < NOW())", record.UserID)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	logger.Debug().Int32("userID", record.UserID).Str("key", record.Key).Msg("try to reserve key")
+	cTag, err := ips.db.Exec(ctx,
+		"INSERT INTO idempotency_keys "+
+			"(user_id, key, method, path, request_hash, expires_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6) "+
+			"ON CONFLICT (user_id, key) DO NOTHING",
+		record.UserID, record.Key, record.Method, record.Path, record.RequestHash, record.ExpiresAt)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	if cTag.RowsAffected() != 0 {
+		return nil, nil
+	}
+
+	var stored = new(idempotency.Record)
+	err = ips.db.QueryRow(ctx,
+		"SELECT user_id, key, method, path, request_hash, status_code, content_type, body, expires_at "+
+			"FROM idempotency_keys "+
+			"WHERE (user_id = $1) AND (key = $2)", record.UserID, record.Key).
+		Scan(&stored.UserID, &stored.Key, &stored.Method, &stored.Path, &stored.RequestHash,
+			&stored.StatusCode, &stored.ContentType, &stored.Body, &stored.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the record was released by a concurrent request in the meantime
+		return ips.Reserve(ctx, record)
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	logger.Debug().Int("statusCode", stored.StatusCode).Msg("key already reserved")
+	return stored, nil
+}
+
+func (ips *IdempotencyPostgresStorage) Complete(ctx context.Context, record *idempotency.Record) error {
+	logger := log.With().Str("package", "postgres").Str("func", "Complete").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := ips.db.Exec(ctx,
+		"UPDATE idempotency_keys "+
+			"SET status_code = $3, content_type = $4, body = $5 "+
+			"WHERE (user_id = $1) AND (key = $2)",
+		record.UserID, record.Key, record.StatusCode, record.ContentType, record.Body)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
+
+func (ips *IdempotencyPostgresStorage) Release(ctx context.Context, userID int32, key string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "Release").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := ips.db.Exec(ctx,
+		"DELETE FROM idempotency_keys "+
+			"WHERE (user_id = $1) AND (key = $2) AND (status_code = 0)", userID, key)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
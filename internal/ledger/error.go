@@ -0,0 +1,10 @@
+package ledger
+
+import "errors"
+
+var (
+	ErrEmptyEntry         = errors.New("ledger entry has no postings")
+	ErrUnbalancedEntry    = errors.New("ledger entry postings do not sum to zero")
+	ErrZeroPosting        = errors.New("ledger posting amount is zero")
+	ErrEntryAlreadyPosted = errors.New("ledger entry already posted")
+)
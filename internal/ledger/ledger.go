@@ -0,0 +1,114 @@
+package ledger
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	AccountKindPoints    = "POINTS"
+	AccountKindWithdrawn = "WITHDRAWN"
+	AccountKindAccrual   = "ACCRUAL"
+	// AccountKindForfeited of the system user collects the points of deleted accounts.
+	AccountKindForfeited = "FORFEITED"
+)
+
+const (
+	EntryKindAccrual    = "ACCRUAL"
+	EntryKindWithdrawal = "WITHDRAWAL"
+	EntryKindForfeit    = "FORFEIT"
+)
+
+// SystemUserID owns the accounts that are not bound to any user,
+// e.g. the source of all accrued points.
+const SystemUserID int32 = 0
+
+type Account struct {
+	UserID int32
+	Kind   string
+}
+
+// Posting amounts are stored in minor units (1/100 of a point).
+type Posting struct {
+	Account Account
+	Amount  int64
+}
+
+type Entry struct {
+	ID        int64
+	Kind      string
+	Reference string
+	CreatedAt time.Time
+	Postings  []Posting
+}
+
+type Balance struct {
+	Current   int64
+	Withdrawn int64
+}
+
+func NewAccrualEntry(userID int32, orderNumber string, amount int64) *Entry {
+	return &Entry{
+		Kind:      EntryKindAccrual,
+		Reference: orderNumber,
+		Postings: []Posting{
+			{Account: Account{UserID: userID, Kind: AccountKindPoints}, Amount: amount},
+			{Account: Account{UserID: SystemUserID, Kind: AccountKindAccrual}, Amount: -amount},
+		},
+	}
+}
+
+func NewWithdrawalEntry(userID int32, orderNumber string, amount int64) *Entry {
+	return &Entry{
+		Kind:      EntryKindWithdrawal,
+		Reference: orderNumber,
+		Postings: []Posting{
+			{Account: Account{UserID: userID, Kind: AccountKindPoints}, Amount: -amount},
+			{Account: Account{UserID: userID, Kind: AccountKindWithdrawn}, Amount: amount},
+		},
+	}
+}
+
+// NewForfeitEntry moves the remaining points of a deleted account to the
+// system, so the journal still balances after the user is gone. An account
+// is forfeited once, the reference is the user.
+func NewForfeitEntry(userID int32, amount int64) *Entry {
+	return &Entry{
+		Kind:      EntryKindForfeit,
+		Reference: "user:" + strconv.FormatInt(int64(userID), 10),
+		Postings: []Posting{
+			{Account: Account{UserID: userID, Kind: AccountKindPoints}, Amount: -amount},
+			{Account: Account{UserID: SystemUserID, Kind: AccountKindForfeited}, Amount: amount},
+		},
+	}
+}
+
+func (e *Entry) Validate() error {
+	if len(e.Postings) < 2 {
+		return ErrEmptyEntry
+	}
+
+	var sum int64
+	for _, p := range e.Postings {
+		if p.Amount == 0 {
+			return ErrZeroPosting
+		}
+		sum += p.Amount
+	}
+
+	if sum != 0 {
+		return ErrUnbalancedEntry
+	}
+	return nil
+}
+
+// Amount returns the amount posted to the given account kind of the user.
+func (e *Entry) Amount(userID int32, accountKind string) int64 {
+	var result int64
+	for _, p := range e.Postings {
+		if p.Account.UserID == userID && p.Account.Kind == accountKind {
+			result += p.Amount
+		}
+	}
+	return result
+}
@@ -0,0 +1,38 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryValidate(t *testing.T) {
+	assert.NoError(t, NewAccrualEntry(1, "12345678903", 50050).Validate())
+	assert.NoError(t, NewWithdrawalEntry(1, "2377225624", 75100).Validate())
+
+	entry := &Entry{Kind: EntryKindAccrual, Reference: "12345678903"}
+	assert.Equal(t, ErrEmptyEntry, entry.Validate())
+
+	entry.Postings = []Posting{
+		{Account: Account{UserID: 1, Kind: AccountKindPoints}, Amount: 100},
+		{Account: Account{UserID: SystemUserID, Kind: AccountKindAccrual}, Amount: -99},
+	}
+	assert.Equal(t, ErrUnbalancedEntry, entry.Validate())
+
+	entry = NewAccrualEntry(1, "12345678903", 0)
+	assert.Equal(t, ErrZeroPosting, entry.Validate())
+}
+
+func TestEntryAmount(t *testing.T) {
+	entry := NewWithdrawalEntry(1, "2377225624", 75100)
+
+	assert.Equal(t, int64(-75100), entry.Amount(1, AccountKindPoints))
+	assert.Equal(t, int64(75100), entry.Amount(1, AccountKindWithdrawn))
+	assert.Equal(t, int64(0), entry.Amount(2, AccountKindWithdrawn))
+
+	entry = NewForfeitEntry(1, 1000)
+	assert.NoError(t, entry.Validate())
+	assert.Equal(t, "user:1", entry.Reference)
+	assert.Equal(t, int64(-1000), entry.Amount(1, AccountKindPoints))
+	assert.Equal(t, int64(1000), entry.Amount(SystemUserID, AccountKindForfeited))
+}
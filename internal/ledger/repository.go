@@ -0,0 +1,33 @@
+package ledger
+
+import (
+	"context"
+	"time"
+)
+
+// Repository keeps the journal. GetBalance with zero at returns the balance
+// including every posted entry.
+type Repository interface {
+	PostEntry(ctx context.Context, entry *Entry) error
+	GetBalance(ctx context.Context, userID int32, at time.Time) (*Balance, error)
+	GetEntriesByUserID(ctx context.Context, userID int32, filter *EntryFilter) ([]*Entry, error)
+	GetEntriesTotal(ctx context.Context, userID int32, filter *EntryFilter, accountKind string) (*Total, error)
+}
+
+// EntryFilter selects the entries of the kind in the posting order. Zero
+// From and To do not limit the period, To is exclusive. The page starts
+// after the entry AfterID created at AfterCreatedAt, zero Limit returns all.
+type EntryFilter struct {
+	Kind           string
+	From           time.Time
+	To             time.Time
+	AfterCreatedAt time.Time
+	AfterID        int64
+	Limit          int
+}
+
+// Total of the entries selected by a filter, the page fields are ignored.
+type Total struct {
+	Count  int
+	Amount int64
+}
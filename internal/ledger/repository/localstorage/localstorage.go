@@ -0,0 +1,123 @@
+package localstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/ledger"
+)
+
+type LedgerLocalStorage struct {
+	entries []*ledger.Entry
+	mutex   *sync.Mutex
+}
+
+func NewLedgerLocalStorage() ledger.Repository {
+	return &LedgerLocalStorage{
+		entries: make([]*ledger.Entry, 0),
+		mutex:   new(sync.Mutex),
+	}
+}
+
+func (lls *LedgerLocalStorage) PostEntry(ctx context.Context, entry *ledger.Entry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	lls.mutex.Lock()
+	defer lls.mutex.Unlock()
+
+	for _, item := range lls.entries {
+		if item.Kind == entry.Kind && item.Reference == entry.Reference {
+			return ledger.ErrEntryAlreadyPosted
+		}
+	}
+
+	posted := *entry
+	posted.ID = int64(len(lls.entries) + 1)
+	posted.CreatedAt = time.Now()
+	posted.Postings = append([]ledger.Posting(nil), entry.Postings...)
+	lls.entries = append(lls.entries, &posted)
+
+	entry.ID = posted.ID
+	entry.CreatedAt = posted.CreatedAt
+	return nil
+}
+
+func (lls *LedgerLocalStorage) GetBalance(ctx context.Context, userID int32, at time.Time) (*ledger.Balance, error) {
+	lls.mutex.Lock()
+	defer lls.mutex.Unlock()
+
+	var result = new(ledger.Balance)
+	for _, item := range lls.entries {
+		if !at.IsZero() && item.CreatedAt.After(at) {
+			continue
+		}
+		result.Current += item.Amount(userID, ledger.AccountKindPoints)
+		result.Withdrawn += item.Amount(userID, ledger.AccountKindWithdrawn)
+	}
+	return result, nil
+}
+
+func (lls *LedgerLocalStorage) GetEntriesByUserID(ctx context.Context, userID int32, filter *ledger.EntryFilter) ([]*ledger.Entry, error) {
+	lls.mutex.Lock()
+	defer lls.mutex.Unlock()
+
+	result := make([]*ledger.Entry, 0)
+	for _, item := range lls.selectEntries(userID, filter) {
+		if filter.AfterID != 0 && !after(item, filter) {
+			continue
+		}
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func (lls *LedgerLocalStorage) GetEntriesTotal(ctx context.Context, userID int32, filter *ledger.EntryFilter, accountKind string) (*ledger.Total, error) {
+	lls.mutex.Lock()
+	defer lls.mutex.Unlock()
+
+	var result = new(ledger.Total)
+	for _, item := range lls.selectEntries(userID, filter) {
+		result.Count++
+		result.Amount += item.Amount(userID, accountKind)
+	}
+	return result, nil
+}
+
+// selectEntries returns the entries of the user matching the kind and the
+// period of the filter, the entries are kept in the posting order.
+func (lls *LedgerLocalStorage) selectEntries(userID int32, filter *ledger.EntryFilter) []*ledger.Entry {
+	result := make([]*ledger.Entry, 0)
+	for _, item := range lls.entries {
+		if item.Kind != filter.Kind {
+			continue
+		}
+		if !filter.From.IsZero() && item.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !item.CreatedAt.Before(filter.To) {
+			continue
+		}
+		for _, p := range item.Postings {
+			if p.Account.UserID == userID {
+				result = append(result, item)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// after compares with the precision of the database, the cursors keep microseconds.
+func after(item *ledger.Entry, filter *ledger.EntryFilter) bool {
+	createdAt := item.CreatedAt.Truncate(time.Microsecond)
+	if !createdAt.Equal(filter.AfterCreatedAt) {
+		return createdAt.After(filter.AfterCreatedAt)
+	}
+	return item.ID > filter.AfterID
+}
@@ -0,0 +1,68 @@
+package localstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBalance(t *testing.T) {
+	storage := NewLedgerLocalStorage()
+	ctx := context.Background()
+
+	before := time.Now()
+	err := storage.PostEntry(ctx, ledger.NewAccrualEntry(1, "12345678903", 50050))
+	assert.NoError(t, err)
+
+	err = storage.PostEntry(ctx, ledger.NewAccrualEntry(1, "12345678903", 50050))
+	assert.Equal(t, ledger.ErrEntryAlreadyPosted, err)
+
+	err = storage.PostEntry(ctx, ledger.NewWithdrawalEntry(1, "2377225624", 4200))
+	assert.NoError(t, err)
+
+	balance, err := storage.GetBalance(ctx, 1, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, &ledger.Balance{Current: 45850, Withdrawn: 4200}, balance)
+
+	balance, err = storage.GetBalance(ctx, 1, before)
+	assert.NoError(t, err)
+	assert.Equal(t, &ledger.Balance{}, balance)
+
+	entries, err := storage.GetEntriesByUserID(ctx, 1, &ledger.EntryFilter{Kind: ledger.EntryKindWithdrawal})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "2377225624", entries[0].Reference)
+}
+
+func TestGetEntriesPage(t *testing.T) {
+	storage := NewLedgerLocalStorage()
+	ctx := context.Background()
+
+	assert.NoError(t, storage.PostEntry(ctx, ledger.NewAccrualEntry(1, "12345678903", 50050)))
+	for _, number := range []string{"1", "2", "3"} {
+		assert.NoError(t, storage.PostEntry(ctx, ledger.NewWithdrawalEntry(1, number, 100)))
+	}
+	assert.NoError(t, storage.PostEntry(ctx, ledger.NewWithdrawalEntry(2, "4", 100)))
+
+	filter := &ledger.EntryFilter{Kind: ledger.EntryKindWithdrawal, Limit: 2}
+	entries, err := storage.GetEntriesByUserID(ctx, 1, filter)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	filter.AfterCreatedAt, filter.AfterID = entries[1].CreatedAt, entries[1].ID
+	entries, err = storage.GetEntriesByUserID(ctx, 1, filter)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "3", entries[0].Reference)
+
+	total, err := storage.GetEntriesTotal(ctx, 1, filter, ledger.AccountKindWithdrawn)
+	assert.NoError(t, err)
+	assert.Equal(t, &ledger.Total{Count: 3, Amount: 300}, total)
+
+	total, err = storage.GetEntriesTotal(ctx, 1, &ledger.EntryFilter{Kind: ledger.EntryKindWithdrawal, To: entries[0].CreatedAt.Add(-time.Hour)}, ledger.AccountKindWithdrawn)
+	assert.NoError(t, err)
+	assert.Equal(t, &ledger.Total{}, total)
+}
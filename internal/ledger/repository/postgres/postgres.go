@@ -0,0 +1,261 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/ledger"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Querier is implemented by *pgxpool.Pool, *pgx.Conn and pgx.Tx, so the ledger can
+// take part in a transaction started by another repository.
+type Querier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+type LedgerPostgresStorage struct {
+	db Querier
+}
+
+func NewLedgerPostgresStorage(db Querier) *LedgerPostgresStorage {
+	return &LedgerPostgresStorage{
+		db: db,
+	}
+}
+
+func (lps *LedgerPostgresStorage) WithTx(tx pgx.Tx) *LedgerPostgresStorage {
+	return &LedgerPostgresStorage{
+		db: tx,
+	}
+}
+
+func (lps *LedgerPostgresStorage) PostEntry(ctx context.Context, entry *ledger.Entry) error {
+	logger := log.With().Str("package", "postgres").Str("func", "PostEntry").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	if err := entry.Validate(); err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+
+	tx, err := lps.db.Begin(ctx)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	logger.Debug().Str("kind", entry.Kind).Str("reference", entry.Reference).Msg("try to post entry")
+	err = tx.QueryRow(ctx,
+		"INSERT INTO ledger_entries "+
+			"(kind, reference) "+
+			"VALUES ($1, $2) "+
+			"RETURNING id, created_at", entry.Kind, entry.Reference).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			logger.Debug().Msg("entry already posted")
+			return ledger.ErrEntryAlreadyPosted
+		}
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+
+	for _, p := range entry.Postings {
+		var accountID int64
+		err = tx.QueryRow(ctx,
+			"INSERT INTO ledger_accounts "+
+				"(user_id, kind) "+
+				"VALUES ($1, $2) "+
+				"ON CONFLICT (user_id, kind) DO UPDATE SET kind = EXCLUDED.kind "+
+				"RETURNING id", p.Account.UserID, p.Account.Kind).Scan(&accountID)
+		if err != nil {
+			logger.Debug().Err(err).Msg("exit with error")
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			"INSERT INTO ledger_postings "+
+				"(entry_id, account_id, amount) "+
+				"VALUES ($1, $2, $3)", entry.ID, accountID, p.Amount)
+		if err != nil {
+			logger.Debug().Err(err).Msg("exit with error")
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// LockAccount takes a row lock on the account until the end of the current
+// transaction, so concurrent balance checks of the same account are serialized.
+func (lps *LedgerPostgresStorage) LockAccount(ctx context.Context, account ledger.Account) error {
+	logger := log.With().Str("package", "postgres").Str("func", "LockAccount").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := lps.db.Exec(ctx,
+		"INSERT INTO ledger_accounts "+
+			"(user_id, kind) "+
+			"VALUES ($1, $2) "+
+			"ON CONFLICT (user_id, kind) DO NOTHING", account.UserID, account.Kind)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+
+	var accountID int64
+	err = lps.db.QueryRow(ctx,
+		"SELECT id FROM ledger_accounts "+
+			"WHERE (user_id = $1) AND (kind = $2) "+
+			"FOR UPDATE", account.UserID, account.Kind).Scan(&accountID)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return err
+	}
+
+	logger.Debug().Int32("userID", account.UserID).Str("kind", account.Kind).Int64("accountID", accountID).Msg("account locked")
+	return nil
+}
+
+func (lps *LedgerPostgresStorage) GetBalance(ctx context.Context, userID int32, at time.Time) (*ledger.Balance, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "GetBalance").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	var atValue interface{}
+	if !at.IsZero() {
+		atValue = at
+	}
+
+	var result = new(ledger.Balance)
+	err := lps.db.QueryRow(ctx,
+		"SELECT "+
+			"COALESCE(SUM(p.amount) FILTER (WHERE a.kind = $2), 0), "+
+			"COALESCE(SUM(p.amount) FILTER (WHERE a.kind = $3), 0) "+
+			"FROM ledger_postings p "+
+			"JOIN ledger_accounts a ON a.id = p.account_id "+
+			"JOIN ledger_entries e ON e.id = p.entry_id "+
+			"WHERE (a.user_id = $1) AND ($4::TIMESTAMPTZ IS NULL OR e.created_at <= $4)",
+		userID, ledger.AccountKindPoints, ledger.AccountKindWithdrawn, atValue).Scan(&result.Current, &result.Withdrawn)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	logger.Debug().Int32("userID", userID).Int64("current", result.Current).Int64("withdrawn", result.Withdrawn).Msg("balance")
+	return result, nil
+}
+
+func (lps *LedgerPostgresStorage) GetEntriesByUserID(ctx context.Context, userID int32, filter *ledger.EntryFilter) ([]*ledger.Entry, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "GetEntriesByUserID").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	result := make([]*ledger.Entry, 0)
+
+	conditions, args := entryConditions(userID, filter)
+	if filter.AfterID != 0 {
+		args = append(args, filter.AfterCreatedAt, filter.AfterID)
+		conditions = append(conditions, "((e.created_at, e.id) > ($"+strconv.Itoa(len(args)-1)+", $"+strconv.Itoa(len(args))+"))")
+	}
+	limit := ""
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		limit = "LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := lps.db.Query(ctx,
+		"WITH selected AS ("+
+			"SELECT e.id, e.kind, e.reference, e.created_at "+
+			"FROM ledger_entries e "+
+			"WHERE "+strings.Join(conditions, " AND ")+" "+
+			"ORDER BY e.created_at ASC, e.id ASC "+limit+") "+
+			"SELECT s.id, s.kind, s.reference, s.created_at, a.user_id, a.kind, p.amount "+
+			"FROM selected s "+
+			"JOIN ledger_postings p ON p.entry_id = s.id "+
+			"JOIN ledger_accounts a ON a.id = p.account_id "+
+			"ORDER BY s.created_at ASC, s.id ASC, p.id ASC", args...)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entry *ledger.Entry
+	for rows.Next() {
+		var item ledger.Entry
+		var p ledger.Posting
+		err := rows.Scan(&item.ID, &item.Kind, &item.Reference, &item.CreatedAt, &p.Account.UserID, &p.Account.Kind, &p.Amount)
+		if err != nil {
+			logger.Debug().Err(err).Msg("exit with error")
+			return nil, err
+		}
+		if entry == nil || entry.ID != item.ID {
+			entry = &item
+			result = append(result, entry)
+		}
+		entry.Postings = append(entry.Postings, p)
+	}
+
+	return result, rows.Err()
+}
+
+func (lps *LedgerPostgresStorage) GetEntriesTotal(ctx context.Context, userID int32, filter *ledger.EntryFilter, accountKind string) (*ledger.Total, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "GetEntriesTotal").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	conditions, args := entryConditions(userID, filter)
+	args = append(args, accountKind)
+
+	var result = new(ledger.Total)
+	err := lps.db.QueryRow(ctx,
+		"SELECT COUNT(*), COALESCE(SUM(("+
+			"SELECT SUM(p.amount) FROM ledger_postings p "+
+			"JOIN ledger_accounts a ON a.id = p.account_id "+
+			"WHERE (p.entry_id = e.id) AND (a.user_id = $1) AND (a.kind = $"+strconv.Itoa(len(args))+"))), 0) "+
+			"FROM ledger_entries e "+
+			"WHERE "+strings.Join(conditions, " AND "), args...).Scan(&result.Count, &result.Amount)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+	return result, nil
+}
+
+// entryConditions selects the entries of the user by the kind and the period of the filter.
+func entryConditions(userID int32, filter *ledger.EntryFilter) ([]string, []interface{}) {
+	args := []interface{}{userID, filter.Kind}
+	conditions := []string{
+		"(e.kind = $2)",
+		"e.id IN (" +
+			"SELECT up.entry_id FROM ledger_postings up " +
+			"JOIN ledger_accounts ua ON ua.id = up.account_id " +
+			"WHERE ua.user_id = $1)",
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, "(e.created_at >= $"+strconv.Itoa(len(args))+")")
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, "(e.created_at < $"+strconv.Itoa(len(args))+")")
+	}
+	return conditions, args
+}
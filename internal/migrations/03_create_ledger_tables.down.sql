@@ -0,0 +1,17 @@
+BEGIN;
+
+INSERT INTO orders (user_id, order_id, debet, order_status, accrual, uploaded_at)
+SELECT a.user_id, e.reference, FALSE, 'WITHDRAWN', p.amount, e.created_at
+FROM ledger_entries e
+JOIN ledger_postings p ON p.entry_id = e.id
+JOIN ledger_accounts a ON a.id = p.account_id
+WHERE e.kind = 'WITHDRAWAL' AND a.kind = 'POINTS'
+ON CONFLICT (order_id) DO NOTHING;
+
+DROP TABLE ledger_postings;
+DROP TABLE ledger_entries;
+DROP TABLE ledger_accounts;
+DROP FUNCTION ledger_forbid_change();
+DROP FUNCTION ledger_check_entry_balance();
+
+COMMIT;
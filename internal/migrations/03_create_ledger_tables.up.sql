@@ -0,0 +1,102 @@
+BEGIN;
+
+CREATE TABLE ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    UNIQUE (user_id, kind)
+);
+
+CREATE TABLE ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL,
+    reference VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (kind, reference)
+);
+
+CREATE TABLE ledger_postings (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES ledger_entries (id),
+    account_id INTEGER NOT NULL REFERENCES ledger_accounts (id),
+    amount BIGINT NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX ledger_postings_account_id_idx ON ledger_postings (account_id);
+CREATE INDEX ledger_postings_entry_id_idx ON ledger_postings (entry_id);
+
+CREATE FUNCTION ledger_check_entry_balance() RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM ledger_postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_postings_balanced
+    AFTER INSERT ON ledger_postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE ledger_check_entry_balance();
+
+CREATE FUNCTION ledger_forbid_change() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_entries_immutable
+    BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE PROCEDURE ledger_forbid_change();
+
+CREATE TRIGGER ledger_postings_immutable
+    BEFORE UPDATE OR DELETE ON ledger_postings
+    FOR EACH ROW EXECUTE PROCEDURE ledger_forbid_change();
+
+-- move accruals and withdrawals kept in orders to the ledger
+INSERT INTO ledger_accounts (user_id, kind) VALUES (0, 'ACCRUAL');
+
+INSERT INTO ledger_accounts (user_id, kind)
+SELECT DISTINCT o.user_id, k.kind
+FROM orders o CROSS JOIN (VALUES ('POINTS'), ('WITHDRAWN')) AS k (kind)
+WHERE o.user_id IS NOT NULL;
+
+INSERT INTO ledger_entries (kind, reference, created_at)
+SELECT 'ACCRUAL', order_id, uploaded_at FROM orders
+WHERE (debet IS TRUE) AND (accrual > 0);
+
+INSERT INTO ledger_entries (kind, reference, created_at)
+SELECT 'WITHDRAWAL', order_id, uploaded_at FROM orders
+WHERE (debet IS FALSE) AND (accrual < 0);
+
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, o.accrual
+FROM orders o
+JOIN ledger_entries e ON e.kind = 'ACCRUAL' AND e.reference = o.order_id
+JOIN ledger_accounts a ON a.user_id = o.user_id AND a.kind = 'POINTS'
+WHERE o.debet IS TRUE;
+
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, -o.accrual
+FROM orders o
+JOIN ledger_entries e ON e.kind = 'ACCRUAL' AND e.reference = o.order_id
+JOIN ledger_accounts a ON a.user_id = 0 AND a.kind = 'ACCRUAL'
+WHERE o.debet IS TRUE;
+
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, o.accrual
+FROM orders o
+JOIN ledger_entries e ON e.kind = 'WITHDRAWAL' AND e.reference = o.order_id
+JOIN ledger_accounts a ON a.user_id = o.user_id AND a.kind = 'POINTS'
+WHERE o.debet IS FALSE;
+
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, -o.accrual
+FROM orders o
+JOIN ledger_entries e ON e.kind = 'WITHDRAWAL' AND e.reference = o.order_id
+JOIN ledger_accounts a ON a.user_id = o.user_id AND a.kind = 'WITHDRAWN'
+WHERE o.debet IS FALSE;
+
+DELETE FROM orders WHERE debet IS FALSE;
+
+COMMIT;
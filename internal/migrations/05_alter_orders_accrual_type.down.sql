@@ -0,0 +1 @@
+ALTER TABLE orders ALTER COLUMN accrual TYPE INTEGER;
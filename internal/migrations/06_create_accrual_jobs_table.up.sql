@@ -0,0 +1,14 @@
+CREATE TABLE accrual_jobs (
+    order_id VARCHAR(255) PRIMARY KEY REFERENCES orders (order_id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX accrual_jobs_next_run_at_idx ON accrual_jobs (next_run_at);
+
+INSERT INTO accrual_jobs (order_id)
+SELECT order_id FROM orders
+WHERE (debet IS TRUE) AND order_status NOT IN ('PROCESSED', 'INVALID');
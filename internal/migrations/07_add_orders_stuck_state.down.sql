@@ -0,0 +1 @@
+ALTER TABLE orders DROP COLUMN stuck_at, DROP COLUMN stuck_reason;
@@ -0,0 +1 @@
+ALTER TABLE orders ADD COLUMN stuck_at TIMESTAMPTZ, ADD COLUMN stuck_reason TEXT;
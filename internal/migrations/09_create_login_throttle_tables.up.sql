@@ -0,0 +1,17 @@
+CREATE TABLE login_throttles (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    blocked_until TIMESTAMPTZ
+);
+
+CREATE TABLE login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    login TEXT NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX login_attempts_login_idx ON login_attempts (login, attempted_at);
+CREATE INDEX login_attempts_ip_idx ON login_attempts (ip, attempted_at);
@@ -0,0 +1,18 @@
+CREATE TABLE order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_id VARCHAR(255) NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    status VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX order_status_history_order_id_idx ON order_status_history (order_id, changed_at);
+
+-- the earlier changes are unknown, the orders get NEW at the upload time
+-- and the status they have now at the time of the migration
+INSERT INTO order_status_history (order_id, status, changed_at)
+SELECT order_id, 'NEW', uploaded_at FROM orders
+WHERE debet IS TRUE;
+
+INSERT INTO order_status_history (order_id, status)
+SELECT order_id, order_status FROM orders
+WHERE (debet IS TRUE) AND order_status <> 'NEW';
@@ -0,0 +1,3 @@
+DROP INDEX order_status_history_changed_at_idx;
+
+ALTER TABLE order_status_history DROP COLUMN from_status, DROP COLUMN accrual;
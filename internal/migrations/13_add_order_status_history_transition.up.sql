@@ -0,0 +1,18 @@
+ALTER TABLE order_status_history
+    ADD COLUMN from_status VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN accrual BIGINT NOT NULL DEFAULT 0;
+
+UPDATE order_status_history h
+SET from_status = p.from_status
+FROM (
+    SELECT id, COALESCE(LAG(status) OVER (PARTITION BY order_id ORDER BY changed_at, id), '') AS from_status
+    FROM order_status_history
+) p
+WHERE p.id = h.id;
+
+UPDATE order_status_history h
+SET accrual = o.accrual
+FROM orders o
+WHERE (o.order_id = h.order_id) AND (h.status = 'PROCESSED');
+
+CREATE INDEX order_status_history_changed_at_idx ON order_status_history (changed_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS login_attempts_attempted_at_idx;
+
+ALTER TABLE login_attempts ALTER COLUMN login TYPE TEXT;
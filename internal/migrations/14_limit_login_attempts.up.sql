@@ -0,0 +1,3 @@
+ALTER TABLE login_attempts ALTER COLUMN login TYPE VARCHAR(256) USING left(login, 256);
+
+CREATE INDEX login_attempts_attempted_at_idx ON login_attempts (attempted_at);
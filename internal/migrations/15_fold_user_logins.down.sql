@@ -0,0 +1 @@
+DROP INDEX users_login_folded_idx;
@@ -0,0 +1,18 @@
+-- the logins registered before the normalization are folded as well; of
+-- the logins that fold to the same one the first registered keeps it and
+-- the others get their id appended, '#' is not allowed in the new logins
+WITH folded AS (
+    SELECT id, lower(normalize(login, NFKC)) AS login,
+        ROW_NUMBER() OVER (PARTITION BY lower(normalize(login, NFKC)) ORDER BY id) AS rank
+    FROM users
+)
+UPDATE users u
+SET login = f.login || '#' || u.id
+FROM folded f
+WHERE (f.id = u.id) AND (f.rank > 1);
+
+UPDATE users
+SET login = lower(normalize(login, NFKC))
+WHERE login <> lower(normalize(login, NFKC));
+
+CREATE UNIQUE INDEX users_login_folded_idx ON users (lower(normalize(login, NFKC)));
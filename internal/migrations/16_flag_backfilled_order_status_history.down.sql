@@ -0,0 +1 @@
+ALTER TABLE order_status_history DROP COLUMN backfilled;
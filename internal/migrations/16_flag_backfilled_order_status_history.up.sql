@@ -0,0 +1,14 @@
+ALTER TABLE order_status_history ADD COLUMN backfilled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- migration 12 gave the orders the status they had at the time of the
+-- migration, those rows share that time and come first by id. When no order
+-- had left NEW by then, the first real change is flagged instead, which only
+-- drops a single sample from the latency metrics.
+UPDATE order_status_history
+SET backfilled = TRUE
+WHERE (status <> 'NEW') AND changed_at = (
+    SELECT changed_at FROM order_status_history
+    WHERE status <> 'NEW'
+    ORDER BY id
+    LIMIT 1
+);
@@ -0,0 +1,123 @@
+// Package migrations keeps the database schema. The files are embedded,
+// so migrations do not depend on the working directory.
+package migrations
+
+import (
+	"embed"
+	"errors"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+type Status struct {
+	Version uint
+	Latest  uint
+	Dirty   bool
+}
+
+func newMigrate(dbURI string) (*migrate.Migrate, error) {
+	source, err := iofs.New(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewWithSourceInstance("iofs", source, dbURI)
+}
+
+func run(dbURI string, action func(m *migrate.Migrate) error) error {
+	m, err := newMigrate(dbURI)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	err = action(m)
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+// Up applies all migrations.
+func Up(dbURI string) error {
+	logger := log.With().Str("package", "migrations").Str("function", "Up").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	return run(dbURI, func(m *migrate.Migrate) error {
+		return m.Up()
+	})
+}
+
+// Down rolls back the last applied migration.
+func Down(dbURI string) error {
+	logger := log.With().Str("package", "migrations").Str("function", "Down").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	return run(dbURI, func(m *migrate.Migrate) error {
+		return m.Steps(-1)
+	})
+}
+
+// To migrates up or down to the given version.
+func To(dbURI string, version uint) error {
+	logger := log.With().Str("package", "migrations").Str("function", "To").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	return run(dbURI, func(m *migrate.Migrate) error {
+		return m.Migrate(version)
+	})
+}
+
+// GetStatus returns the applied version, zero when nothing is applied.
+func GetStatus(dbURI string) (*Status, error) {
+	latest, err := LatestVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{Latest: latest}
+	err = run(dbURI, func(m *migrate.Migrate) error {
+		version, dirty, err := m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return nil
+		}
+		status.Version, status.Dirty = version, dirty
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// LatestVersion returns the version of the last embedded migration.
+func LatestVersion() (uint, error) {
+	source, err := iofs.New(files, ".")
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	version, err := source.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := source.Next(version)
+		if err != nil {
+			return version, nil
+		}
+		version = next
+	}
+}
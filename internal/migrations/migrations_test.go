@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	entries, err := fs.ReadDir(files, ".")
+	require.NoError(t, err)
+
+	ups := make(map[string]bool)
+	downs := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			ups[strings.TrimSuffix(name, ".up.sql")] = true
+		case strings.HasSuffix(name, ".down.sql"):
+			downs[strings.TrimSuffix(name, ".down.sql")] = true
+		}
+	}
+	assert.NotEmpty(t, ups)
+	assert.Equal(t, ups, downs, "every migration needs both up and down files")
+
+	latest, err := LatestVersion()
+	require.NoError(t, err)
+	assert.Equal(t, uint(len(ups)), latest)
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type AccrualJob struct {
+	OrderNumber string
+	Attempts    int
+	NextRunAt   time.Time
+	CreatedAt   time.Time
+	LastError   string
+}
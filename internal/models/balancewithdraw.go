@@ -1,6 +1,6 @@
 package models
 
 type BalanceWithdraw struct {
-	OrderID string  `json:"order"`
-	Sum     float32 `json:"sum"`
+	OrderID string `json:"order"`
+	Sum     Money  `json:"sum"`
 }
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of loyalty points kept in minor units,
+// one point is MoneyScale units.
+type Money int64
+
+const (
+	MoneyScale     = 100
+	MoneyPrecision = 2
+)
+
+var (
+	ErrMoneyFormat    = errors.New("bad money format")
+	ErrMoneyPrecision = errors.New("money has more than 2 decimal places")
+	ErrMoneyOverflow  = errors.New("money value is out of range")
+)
+
+// ParseMoney parses a decimal number exactly and rejects values
+// with more than MoneyPrecision decimal places.
+func ParseMoney(s string) (Money, error) {
+	value, err := parseMinorUnits(s)
+	if err != nil {
+		return 0, err
+	}
+	if !value.IsInt() {
+		return 0, ErrMoneyPrecision
+	}
+	return moneyFromInt(value.Num())
+}
+
+// RoundMoney parses a decimal number and rounds it half away from zero
+// to MoneyPrecision decimal places.
+func RoundMoney(s string) (Money, error) {
+	value, err := parseMinorUnits(s)
+	if err != nil {
+		return 0, err
+	}
+
+	num := new(big.Int).Abs(value.Num())
+	denom := value.Denom()
+	quo, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(denom) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if value.Sign() < 0 {
+		quo.Neg(quo)
+	}
+	return moneyFromInt(quo)
+}
+
+func parseMinorUnits(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, "/") {
+		return nil, ErrMoneyFormat
+	}
+
+	value, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, ErrMoneyFormat
+	}
+	return value.Mul(value, big.NewRat(MoneyScale, 1)), nil
+}
+
+func moneyFromInt(value *big.Int) (Money, error) {
+	if !value.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(value.Int64()), nil
+}
+
+func (m Money) String() string {
+	var sign string
+	units := uint64(m)
+	if m < 0 {
+		sign = "-"
+		units = uint64(-m)
+		if m == math.MinInt64 {
+			units = uint64(math.MaxInt64) + 1
+		}
+	}
+
+	result := sign + strconv.FormatUint(units/MoneyScale, 10)
+	if fraction := units % MoneyScale; fraction != 0 {
+		digits := strconv.FormatUint(fraction+MoneyScale, 10)[1:]
+		result += "." + strings.TrimRight(digits, "0")
+	}
+	return result
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, "\"") {
+		return ErrMoneyFormat
+	}
+
+	value, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = value
+	return nil
+}
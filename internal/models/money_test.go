@@ -0,0 +1,77 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Money
+		err   error
+	}{
+		{value: "751", want: 75100},
+		{value: "500.5", want: 50050},
+		{value: "729.98", want: 72998},
+		{value: "0.01", want: 1},
+		{value: "-42", want: -4200},
+		{value: "7.5e2", want: 75000},
+		{value: "92233720368547758.07", want: 9223372036854775807},
+		{value: "0.001", err: ErrMoneyPrecision},
+		{value: "92233720368547758.08", err: ErrMoneyOverflow},
+		{value: "abc", err: ErrMoneyFormat},
+		{value: "1/2", err: ErrMoneyFormat},
+		{value: "", err: ErrMoneyFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseMoney(tt.value)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRoundMoney(t *testing.T) {
+	got, err := RoundMoney("0.005")
+	assert.NoError(t, err)
+	assert.Equal(t, Money(1), got)
+
+	got, err = RoundMoney("-0.004")
+	assert.NoError(t, err)
+	assert.Equal(t, Money(0), got)
+
+	got, err = RoundMoney("-1.235")
+	assert.NoError(t, err)
+	assert.Equal(t, Money(-124), got)
+}
+
+func TestMoneyJSON(t *testing.T) {
+	balance := Balance{Current: 50050, Withdrawn: 4200}
+	data, err := json.Marshal(balance)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"current":500.5,"withdrawn":42}`, string(data))
+
+	data, err = json.Marshal(Money(-5))
+	assert.NoError(t, err)
+	assert.Equal(t, "-0.05", string(data))
+
+	var bw BalanceWithdraw
+	err = json.Unmarshal([]byte(`{"order":"2377225624","sum":751.01}`), &bw)
+	assert.NoError(t, err)
+	assert.Equal(t, Money(75101), bw.Sum)
+
+	err = json.Unmarshal([]byte(`{"order":"2377225624","sum":751.011}`), &bw)
+	assert.ErrorIs(t, err, ErrMoneyPrecision)
+
+	err = json.Unmarshal([]byte(`{"order":"2377225624","sum":"751"}`), &bw)
+	assert.ErrorIs(t, err, ErrMoneyFormat)
+
+	order := Order{Number: "12345678903", Status: OrderStatusProcessing}
+	data, err = json.Marshal(order)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "accrual")
+}
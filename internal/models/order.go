@@ -1,6 +1,10 @@
 package models
 
-import "github.com/jackc/pgtype"
+import (
+	"time"
+
+	"github.com/jackc/pgtype"
+)
 
 const (
 	OrderStatusNew        = "NEW"
@@ -10,10 +14,22 @@ const (
 	OrderStatusWithDrawn  = "WITHDRAWN"
 )
 
+// Order is Stuck when the accrual system gave up on it, its status is not
+// polled for any more.
 type Order struct {
 	UserName int32            `json:"-"`
 	Number   string           `json:"number"`
 	Status   string           `json:"status"`
-	Accrual  float32          `json:"accrual,omitempty"`
+	Accrual  Money            `json:"accrual,omitempty"`
+	Stuck    bool             `json:"stuck,omitempty"`
 	Uploaded pgtype.Timestamp `json:"uploaded_at"`
 }
+
+// OrderStatusChange is a transition of an order, FromStatus is empty for the
+// upload. Accrual is the accrual of the order after the transition.
+type OrderStatusChange struct {
+	FromStatus string    `json:"from_status,omitempty"`
+	Status     string    `json:"status"`
+	Accrual    Money     `json:"accrual,omitempty"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
@@ -6,6 +6,6 @@ import (
 
 type Withdrawals struct {
 	OrderID     string    `json:"order"`
-	Sum         float32   `json:"sum"`
+	Sum         Money     `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
 }
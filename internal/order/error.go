@@ -13,4 +13,13 @@ var (
 
 	ErrNotEnougthBalance = errors.New("на счету недостаточно средств")
 	ErrOrderBadNumber    = errors.New("неверный номер заказа")
+	ErrWithdrawBadSum    = errors.New("неверная сумма списания")
+
+	ErrOrderNotFound         = errors.New("заказ не найден")
+	ErrOrderOwnedByOtherUser = errors.New("заказ загружен другим пользователем")
+
+	ErrBadListFilter = errors.New("неверные параметры списка")
+	ErrBadCursor     = errors.New("неверный курсор")
+
+	ErrIllegalTransition = errors.New("недопустимая смена статуса заказа")
 )
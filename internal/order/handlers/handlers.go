@@ -1,35 +1,28 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/alexkopcak/gophermart/internal/auth"
 	"github.com/alexkopcak/gophermart/internal/models"
 	"github.com/alexkopcak/gophermart/internal/order"
-	"github.com/alexkopcak/gophermart/internal/order/integration"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
 
 type OrderHandler struct {
-	OrderUseCase    order.UseCase
-	AccurualService *integration.AccurualService
+	OrderUseCase order.UseCase
 }
 
-func NewOrderHandler(wg *sync.WaitGroup, uc chan *string, ouc order.UseCase, accrualServiceAddress string) *OrderHandler {
-	accrualService := integration.NewAccurualService(wg, uc, accrualServiceAddress, ouc)
-	accrualService.StartUpdateWorker()
-
+func NewOrderHandler(ouc order.UseCase) *OrderHandler {
 	return &OrderHandler{
-		OrderUseCase:    ouc,
-		AccurualService: accrualService,
+		OrderUseCase: ouc,
 	}
 }
 
@@ -38,27 +31,6 @@ type orderItem struct {
 	Uploaded string `json:"uploaded_at"`
 }
 
-func (h *OrderHandler) UpdateNotFinnalizedOrders() {
-	logger := log.With().Str("package", "handlers").Str("function", "UpdateUnhandledOrders").Logger()
-	logger.Debug().Msg("enter")
-	defer logger.Debug().Msg("exit")
-
-	logger.Debug().Msg("Get")
-	orders, err := h.OrderUseCase.GetNotFinnalizedOrdersList(context.Background())
-
-	if err != nil {
-		logger.Debug().Err(err).Msg("exit with error")
-	}
-	logger.Debug().Int("len(orders)", len(orders)).Msg("Not finnalized orders count")
-	if len(orders) == 0 {
-		return
-	}
-
-	for _, item := range orders {
-		h.AccurualService.UpdateChannel <- &item.Number
-	}
-}
-
 func (h *OrderHandler) AddNewOrder(c *gin.Context) {
 	logger := log.With().Str("package", "handlers").Str("function", "AddNewOrder").Logger()
 	logger.Debug().Msg("enter")
@@ -102,12 +74,15 @@ func (h *OrderHandler) AddNewOrder(c *gin.Context) {
 			c.String(http.StatusUnprocessableEntity, "неверный формат номера заказа")
 			return
 		}
+		if errors.Is(err, order.ErrUserNotAuthtorised) {
+			c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+			return
+		}
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	logger.Debug().Str("orderID", orderID).Msg("orderID sent to accurual service")
-	h.AccurualService.UpdateChannel <- &orderID
+	logger.Debug().Str("orderID", orderID).Msg("orderID queued for accurual service")
 
 	c.String(http.StatusAccepted, "новый номер заказа принят в обработку")
 	logger.Debug().Msg("new order has accepted")
@@ -140,26 +115,43 @@ func (h *OrderHandler) GetUserOrders(c *gin.Context) {
 		return
 	}
 
-	orders, err := h.OrderUseCase.GetOrders(c.Request.Context(), userID)
+	filter, err := parseListFilter(c)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error: bad list filter")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
+		return
+	}
+
+	page, err := h.OrderUseCase.GetOrders(c.Request.Context(), userID, filter)
 	logger.Debug().Msg("Get user orders")
+	if errors.Is(err, order.ErrBadListFilter) {
+		logger.Debug().Err(err).Msg("exit with error: bad list filter")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
+		return
+	}
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
 		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
 		return
 	}
 
-	if len(orders) == 0 {
+	if page.Next != nil {
+		c.Header(nextCursorHeader, page.Next.String())
+	}
+
+	if len(page.Orders) == 0 {
 		logger.Debug().Msg("where are no orders")
-		c.JSON(http.StatusNoContent, orders)
+		c.JSON(http.StatusNoContent, page.Orders)
 		return
 	}
 
 	var result []orderItem
-	for _, item := range orders {
+	for _, item := range page.Orders {
 		var resultItem orderItem
 		resultItem.Number = item.Number
 		resultItem.Status = item.Status
 		resultItem.Accrual = item.Accrual
+		resultItem.Stuck = item.Stuck
 		resultItem.Uploaded = item.Uploaded.Time.Format(time.RFC3339)
 
 		result = append(result, resultItem)
@@ -168,6 +160,168 @@ func (h *OrderHandler) GetUserOrders(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+type orderDetails struct {
+	orderItem
+	History []*models.OrderStatusChange `json:"history"`
+}
+
+func (h *OrderHandler) GetUserOrder(c *gin.Context) {
+	logger := log.With().Str("package", "handlers").Str("function", "GetUserOrder").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	userID, err := getUserID(c)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return
+	}
+
+	number := c.Param("number")
+	details, err := h.OrderUseCase.GetOrder(c.Request.Context(), userID, number)
+	if err != nil {
+		logger.Debug().Err(err).Str("order", number).Msg("exit with error")
+		writeOrderLookupError(c, err)
+		return
+	}
+
+	var result orderDetails
+	result.Number = details.Order.Number
+	result.Status = details.Order.Status
+	result.Accrual = details.Order.Accrual
+	result.Stuck = details.Order.Stuck
+	result.Uploaded = details.Order.Uploaded.Time.Format(time.RFC3339)
+	result.History = details.History
+
+	c.JSON(http.StatusOK, result)
+}
+
+type timelineStep struct {
+	FromStatus      string  `json:"from_status,omitempty"`
+	Status          string  `json:"status"`
+	ChangedAt       string  `json:"changed_at"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+type orderTimeline struct {
+	Number            string         `json:"number"`
+	Status            string         `json:"status"`
+	Stuck             bool           `json:"stuck,omitempty"`
+	Finished          bool           `json:"finished"`
+	ProcessingSeconds float64        `json:"processing_seconds"`
+	Steps             []timelineStep `json:"steps"`
+}
+
+func (h *OrderHandler) GetUserOrderTimeline(c *gin.Context) {
+	logger := log.With().Str("package", "handlers").Str("function", "GetUserOrderTimeline").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	userID, err := getUserID(c)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return
+	}
+
+	number := c.Param("number")
+	timeline, err := h.OrderUseCase.GetOrderTimeline(c.Request.Context(), userID, number)
+	if err != nil {
+		logger.Debug().Err(err).Str("order", number).Msg("exit with error")
+		writeOrderLookupError(c, err)
+		return
+	}
+
+	result := orderTimeline{
+		Number:            timeline.Order.Number,
+		Status:            timeline.Order.Status,
+		Stuck:             timeline.Order.Stuck,
+		Finished:          timeline.Finished,
+		ProcessingSeconds: timeline.ProcessingTime.Seconds(),
+		Steps:             make([]timelineStep, 0, len(timeline.Steps)),
+	}
+	for _, step := range timeline.Steps {
+		result.Steps = append(result.Steps, timelineStep{
+			FromStatus:      step.FromStatus,
+			Status:          step.Status,
+			ChangedAt:       step.ChangedAt.Format(time.RFC3339),
+			DurationSeconds: step.Duration.Seconds(),
+		})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func writeOrderLookupError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, order.ErrOrderNotFound):
+		c.String(http.StatusNotFound, "заказ не найден")
+	case errors.Is(err, order.ErrOrderOwnedByOtherUser):
+		c.String(http.StatusForbidden, "заказ загружен другим пользователем")
+	default:
+		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
+	}
+}
+
+const nextCursorHeader = "X-Next-Cursor"
+
+// parseListFilter reads limit, cursor, status (repeated or comma separated),
+// from, to (RFC3339) and sort (asc or desc) of the query.
+func parseListFilter(c *gin.Context) (*order.ListFilter, error) {
+	filter := &order.ListFilter{
+		Sort: strings.ToLower(c.Query("sort")),
+	}
+
+	var err error
+	if filter.Limit, filter.After, err = parsePage(c); err != nil {
+		return nil, err
+	}
+	for _, value := range c.QueryArray("status") {
+		for _, status := range strings.Split(value, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				filter.Statuses = append(filter.Statuses, strings.ToUpper(status))
+			}
+		}
+	}
+	if filter.UploadedFrom, filter.UploadedTo, err = parsePeriod(c); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// parsePage reads the page size and the cursor of the previous page.
+func parsePage(c *gin.Context) (int, *order.Cursor, error) {
+	var limit int
+	var cursor *order.Cursor
+	var err error
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return 0, nil, order.ErrBadListFilter
+		}
+	}
+	if value := c.Query("cursor"); value != "" {
+		if cursor, err = order.ParseCursor(value); err != nil {
+			return 0, nil, err
+		}
+	}
+	return limit, cursor, nil
+}
+
+// parsePeriod reads the from and to times in RFC3339.
+func parsePeriod(c *gin.Context) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, err
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, err
+		}
+	}
+	return from, to, nil
+}
+
 func (h *OrderHandler) GetUserBalance(c *gin.Context) {
 	logger := log.With().Str("package", "handlers").Str("function", "GetUserBalance").Logger()
 	logger.Debug().Msg("enter")
@@ -180,13 +334,25 @@ func (h *OrderHandler) GetUserBalance(c *gin.Context) {
 		return
 	}
 
-	balance, err := h.OrderUseCase.GetBalance(c.Request.Context(), userID)
-	logger.Debug().Float32("current", balance.Current).Float32("withdrawn", balance.Withdrawn).Msg("get user balance")
+	var balance *models.Balance
+	if at := c.Query("at"); at != "" {
+		var atTime time.Time
+		atTime, err = time.Parse(time.RFC3339, at)
+		if err != nil {
+			logger.Debug().Err(err).Msg("exit with error: bad balance time")
+			c.String(http.StatusBadRequest, "неверный формат запроса")
+			return
+		}
+		balance, err = h.OrderUseCase.GetBalanceAt(c.Request.Context(), userID, atTime)
+	} else {
+		balance, err = h.OrderUseCase.GetBalance(c.Request.Context(), userID)
+	}
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
 		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
 		return
 	}
+	logger.Debug().Stringer("current", balance.Current).Stringer("withdrawn", balance.Withdrawn).Msg("get user balance")
 	c.JSON(http.StatusOK, balance)
 }
 
@@ -204,6 +370,14 @@ func (h *OrderHandler) BalanceWithdraw(c *gin.Context) {
 	var balWithdraw models.BalanceWithdraw
 	err := json.NewDecoder(c.Request.Body).Decode(&balWithdraw)
 
+	if errors.Is(err, models.ErrMoneyFormat) ||
+		errors.Is(err, models.ErrMoneyPrecision) ||
+		errors.Is(err, models.ErrMoneyOverflow) {
+		logger.Debug().Err(err).Msg("exit with error: bad sum")
+		c.String(http.StatusBadRequest, "неверная сумма списания")
+		return
+	}
+
 	if err != nil || balWithdraw.OrderID == "" {
 		logger.Debug().Err(err).Msg("exit with error")
 		c.String(http.StatusUnprocessableEntity, "неверный номер заказа")
@@ -223,6 +397,18 @@ func (h *OrderHandler) BalanceWithdraw(c *gin.Context) {
 			c.String(http.StatusUnprocessableEntity, "неверный номер заказа")
 			return
 		}
+		if errors.Is(err, order.ErrWithdrawBadSum) {
+			c.String(http.StatusBadRequest, "неверная сумма списания")
+			return
+		}
+		if errors.Is(err, order.ErrNotEnougthBalance) {
+			c.String(http.StatusPaymentRequired, "на счету недостаточно средств")
+			return
+		}
+		if errors.Is(err, order.ErrUserNotAuthtorised) {
+			c.String(http.StatusUnauthorized, "пользователь не аутентифицирован")
+			return
+		}
 
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -232,6 +418,11 @@ func (h *OrderHandler) BalanceWithdraw(c *gin.Context) {
 	logger.Debug().Msg("query was handled succefuly")
 }
 
+const (
+	totalCountHeader = "X-Total-Count"
+	totalSumHeader   = "X-Total-Sum"
+)
+
 func (h *OrderHandler) Withdrawals(c *gin.Context) {
 	logger := log.With().Str("package", "handlers").Str("function", "Withdrawals").Logger()
 	logger.Debug().Msg("enter")
@@ -243,15 +434,39 @@ func (h *OrderHandler) Withdrawals(c *gin.Context) {
 		return
 	}
 
-	withdrawls, err := h.OrderUseCase.Withdrawals(c.Request.Context(), userID)
+	filter := new(order.WithdrawalsFilter)
+	filter.Limit, filter.After, err = parsePage(c)
+	if err == nil {
+		filter.From, filter.To, err = parsePeriod(c)
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error: bad list filter")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
+		return
+	}
+
+	page, err := h.OrderUseCase.Withdrawals(c.Request.Context(), userID, filter)
+	if errors.Is(err, order.ErrBadListFilter) {
+		logger.Debug().Err(err).Msg("exit with error: bad list filter")
+		c.String(http.StatusBadRequest, "неверный формат запроса")
+		return
+	}
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
 		c.String(http.StatusInternalServerError, "внутренняя ошибка сервера")
 		return
 	}
-	if len(withdrawls) == 0 {
+
+	// the totals of the whole period, the body keeps the array of the specification
+	c.Header(totalCountHeader, strconv.Itoa(page.Count))
+	c.Header(totalSumHeader, page.Total.String())
+	if page.Next != nil {
+		c.Header(nextCursorHeader, page.Next.String())
+	}
+
+	if len(page.Withdrawals) == 0 {
 		c.String(http.StatusNoContent, "нет ни одного списания")
 		return
 	}
-	c.JSON(http.StatusOK, withdrawls)
+	c.JSON(http.StatusOK, page.Withdrawals)
 }
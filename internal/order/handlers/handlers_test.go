@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/auth"
+	idempotencyhandlers "github.com/alexkopcak/gophermart/internal/idempotency/handlers"
+	idempotencylocalstorage "github.com/alexkopcak/gophermart/internal/idempotency/repository/localstorage"
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/alexkopcak/gophermart/internal/order"
+	"github.com/alexkopcak/gophermart/internal/order/integration/accrualtest"
+	"github.com/alexkopcak/gophermart/internal/order/repository/localstorage"
+	"github.com/alexkopcak/gophermart/internal/order/usecase"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func userMiddleware(userID int32) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(auth.CtxUserKey, userID)
+		c.Next()
+	}
+}
+
+func TestBalanceWithdrawConcurrent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	repo := localstorage.NewOrderLocalStorage()
+	ouc := usecase.NewOrderUseCase(repo, localstorage.NewAccrualQueueLocalStorage())
+
+	var userID int32 = 1
+	accrualOrder := accrualtest.LuhnNumber(1000)
+	require.NoError(t, ouc.AddNewOrder(ctx, userID, accrualOrder))
+	require.NoError(t, ouc.UpdateOrder(ctx, accrualOrder, models.OrderStatusProcessed, 10000))
+
+	router := gin.New()
+	RegisterHTTPEndpoints(router, userMiddleware(userID), idempotencyhandlers.IdempotencyMiddlewareHandle(
+		idempotencylocalstorage.NewIdempotencyLocalStorage(), time.Hour), ouc)
+
+	const requests = 50
+	const sum models.Money = 1000
+
+	statuses := make(chan int, requests)
+	start := make(chan struct{})
+	clients := &sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		body, err := json.Marshal(models.BalanceWithdraw{
+			OrderID: accrualtest.LuhnNumber(2000 + i),
+			Sum:     sum,
+		})
+		require.NoError(t, err)
+
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			<-start
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			statuses <- w.Code
+		}()
+	}
+	close(start)
+	clients.Wait()
+	close(statuses)
+
+	succeeded := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusPaymentRequired:
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+
+	balance, err := ouc.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 10, succeeded)
+	assert.Equal(t, models.Money(0), balance.Current)
+	assert.Equal(t, models.Money(10000), balance.Withdrawn)
+
+	page, err := ouc.Withdrawals(ctx, userID, &order.WithdrawalsFilter{})
+	require.NoError(t, err)
+	assert.Len(t, page.Withdrawals, succeeded)
+	assert.Equal(t, succeeded, page.Count)
+	assert.Equal(t, models.Money(10000), page.Total)
+}
+
+func TestGetUserOrdersPages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	ouc := usecase.NewOrderUseCase(localstorage.NewOrderLocalStorage(), localstorage.NewAccrualQueueLocalStorage())
+	var userID int32 = 1
+	for i := 0; i < 3; i++ {
+		require.NoError(t, ouc.AddNewOrder(ctx, userID, accrualtest.LuhnNumber(3000+i)))
+	}
+
+	router := gin.New()
+	RegisterHTTPEndpoints(router, userMiddleware(userID), func(c *gin.Context) { c.Next() }, ouc)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/user/orders"+query, nil))
+		return w
+	}
+
+	w := get("?limit=2&sort=desc")
+	require.Equal(t, http.StatusOK, w.Code)
+	var orders []orderItem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&orders))
+	require.Len(t, orders, 2)
+	assert.Equal(t, accrualtest.LuhnNumber(3002), orders[0].Number)
+	cursor := w.Header().Get(nextCursorHeader)
+	require.NotEmpty(t, cursor)
+
+	w = get("?limit=2&sort=desc&cursor=" + cursor)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&orders))
+	require.Len(t, orders, 1)
+	assert.Equal(t, accrualtest.LuhnNumber(3000), orders[0].Number)
+	assert.Empty(t, w.Header().Get(nextCursorHeader))
+
+	assert.Equal(t, http.StatusNoContent, get("?status=processed,invalid").Code)
+	assert.Equal(t, http.StatusOK, get("?status=NEW&from=2000-01-01T00:00:00Z").Code)
+
+	for _, query := range []string{"?limit=0", "?limit=x", "?cursor=bad", "?sort=up", "?status=DONE", "?from=yesterday"} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query)
+	}
+
+	// the list without the page parameters is whole, like before the pages
+	var otherUserID int32 = 2
+	for i := 0; i <= order.DefaultListLimit; i++ {
+		require.NoError(t, ouc.AddNewOrder(ctx, otherUserID, accrualtest.LuhnNumber(4000+i)))
+	}
+	router = gin.New()
+	RegisterHTTPEndpoints(router, userMiddleware(otherUserID), func(c *gin.Context) { c.Next() }, ouc)
+
+	w = get("")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&orders))
+	assert.Len(t, orders, order.DefaultListLimit+1)
+	assert.Empty(t, w.Header().Get(nextCursorHeader))
+}
+
+func TestWithdrawalsPages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	ouc := usecase.NewOrderUseCase(localstorage.NewOrderLocalStorage(), localstorage.NewAccrualQueueLocalStorage())
+	var userID int32 = 1
+	accrualOrder := accrualtest.LuhnNumber(4000)
+	require.NoError(t, ouc.AddNewOrder(ctx, userID, accrualOrder))
+	require.NoError(t, ouc.UpdateOrder(ctx, accrualOrder, models.OrderStatusProcessed, 10000))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, ouc.BalanceWithdraw(ctx, userID, &models.BalanceWithdraw{OrderID: accrualtest.LuhnNumber(4001 + i), Sum: 1050}))
+	}
+
+	router := gin.New()
+	RegisterHTTPEndpoints(router, userMiddleware(userID), func(c *gin.Context) { c.Next() }, ouc)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	var numbers []string
+	url := "/api/user/balance/withdrawals?limit=2"
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		w := get(url)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "3", w.Header().Get(totalCountHeader))
+		assert.Equal(t, "31.5", w.Header().Get(totalSumHeader))
+
+		var withdrawals []models.Withdrawals
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&withdrawals))
+		for _, item := range withdrawals {
+			numbers = append(numbers, item.OrderID)
+		}
+
+		cursor := w.Header().Get(nextCursorHeader)
+		if cursor == "" {
+			break
+		}
+		url = "/api/user/balance/withdrawals?limit=2&cursor=" + cursor
+	}
+	assert.Equal(t, []string{accrualtest.LuhnNumber(4001), accrualtest.LuhnNumber(4002), accrualtest.LuhnNumber(4003)}, numbers)
+
+	w := get("/api/user/withdrawals")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = get("/api/user/balance/withdrawals?to=2000-01-01T00:00:00Z")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "0", w.Header().Get(totalCountHeader))
+
+	for _, query := range []string{"?limit=-1", "?cursor=bad", "?from=yesterday", "?from=2000-01-02T00:00:00Z&to=2000-01-01T00:00:00Z"} {
+		assert.Equal(t, http.StatusBadRequest, get("/api/user/balance/withdrawals"+query).Code, query)
+	}
+}
+
+func TestGetUserOrder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	ouc := usecase.NewOrderUseCase(localstorage.NewOrderLocalStorage(), localstorage.NewAccrualQueueLocalStorage())
+	number := accrualtest.LuhnNumber(5000)
+	require.NoError(t, ouc.AddNewOrder(ctx, 1, number))
+	require.NoError(t, ouc.UpdateOrder(ctx, number, models.OrderStatusProcessing, 0))
+	require.NoError(t, ouc.UpdateOrder(ctx, number, models.OrderStatusProcessing, 0))
+	require.NoError(t, ouc.UpdateOrder(ctx, number, models.OrderStatusProcessed, 72998))
+
+	get := func(userID int32, number string) *httptest.ResponseRecorder {
+		router := gin.New()
+		RegisterHTTPEndpoints(router, userMiddleware(userID), func(c *gin.Context) { c.Next() }, ouc)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/user/orders/"+number, nil))
+		return w
+	}
+
+	w := get(1, number)
+	require.Equal(t, http.StatusOK, w.Code)
+	var result orderDetails
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.Equal(t, number, result.Number)
+	assert.Equal(t, models.OrderStatusProcessed, result.Status)
+	assert.Equal(t, models.Money(72998), result.Accrual)
+	assert.NotEmpty(t, result.Uploaded)
+
+	statuses := make([]string, 0, len(result.History))
+	for _, change := range result.History {
+		statuses = append(statuses, change.Status)
+	}
+	assert.Equal(t, []string{models.OrderStatusNew, models.OrderStatusProcessing, models.OrderStatusProcessed}, statuses)
+
+	assert.Equal(t, http.StatusForbidden, get(2, number).Code)
+	assert.Equal(t, http.StatusNotFound, get(1, accrualtest.LuhnNumber(5001)).Code)
+	assert.False(t, result.Stuck)
+
+	// the order the accrual system gave up on stays NEW and is marked stuck
+	stuck := accrualtest.LuhnNumber(5002)
+	require.NoError(t, ouc.AddNewOrder(ctx, 1, stuck))
+	require.NoError(t, ouc.MarkOrderStuck(ctx, stuck, "accrual system responded with 500"))
+
+	w = get(1, stuck)
+	require.Equal(t, http.StatusOK, w.Code)
+	result = orderDetails{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.Equal(t, models.OrderStatusNew, result.Status)
+	assert.True(t, result.Stuck)
+
+	router := gin.New()
+	RegisterHTTPEndpoints(router, userMiddleware(1), func(c *gin.Context) { c.Next() }, ouc)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/user/orders", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var orders []orderItem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&orders))
+	require.Len(t, orders, 2)
+	assert.False(t, orders[0].Stuck)
+	assert.True(t, orders[1].Stuck)
+}
+
+func TestGetUserOrderTimeline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	ouc := usecase.NewOrderUseCase(localstorage.NewOrderLocalStorage(), localstorage.NewAccrualQueueLocalStorage())
+	number := accrualtest.LuhnNumber(5100)
+	require.NoError(t, ouc.AddNewOrder(ctx, 1, number))
+	require.NoError(t, ouc.UpdateOrder(ctx, number, models.OrderStatusProcessing, 0))
+
+	get := func(userID int32, number string) *httptest.ResponseRecorder {
+		router := gin.New()
+		RegisterHTTPEndpoints(router, userMiddleware(userID), func(c *gin.Context) { c.Next() }, ouc)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/user/orders/"+number+"/timeline", nil))
+		return w
+	}
+
+	w := get(1, number)
+	require.Equal(t, http.StatusOK, w.Code)
+	var result orderTimeline
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.Equal(t, number, result.Number)
+	assert.False(t, result.Finished)
+	require.Len(t, result.Steps, 2)
+	assert.Equal(t, models.OrderStatusNew, result.Steps[1].FromStatus)
+	assert.Equal(t, models.OrderStatusProcessing, result.Steps[1].Status)
+
+	require.NoError(t, ouc.UpdateOrder(ctx, number, models.OrderStatusProcessed, 100))
+	w = get(1, number)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.True(t, result.Finished)
+	assert.Len(t, result.Steps, 3)
+
+	assert.Equal(t, http.StatusForbidden, get(2, number).Code)
+	assert.Equal(t, http.StatusNotFound, get(1, accrualtest.LuhnNumber(5101)).Code)
+}
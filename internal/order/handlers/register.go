@@ -1,21 +1,22 @@
 package handlers
 
 import (
-	"sync"
-
 	"github.com/alexkopcak/gophermart/internal/order"
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterHTTPEndpoints(wg *sync.WaitGroup, uChannel chan *string, router *gin.Engine, midlleware gin.HandlerFunc, ouc order.UseCase, asAddress string) {
-	handler := NewOrderHandler(wg, uChannel, ouc, asAddress)
-	handler.UpdateNotFinnalizedOrders()
+func RegisterHTTPEndpoints(router *gin.Engine, midlleware gin.HandlerFunc, idempotencyMiddleware gin.HandlerFunc, ouc order.UseCase) {
+	handler := NewOrderHandler(ouc)
 
 	routes := router.Use(midlleware)
 
-	routes.POST("/api/user/orders", handler.AddNewOrder)
+	routes.POST("/api/user/orders", idempotencyMiddleware, handler.AddNewOrder)
 	routes.GET("/api/user/orders", handler.GetUserOrders)
+	routes.GET("/api/user/orders/:number", handler.GetUserOrder)
+	routes.GET("/api/user/orders/:number/timeline", handler.GetUserOrderTimeline)
 	routes.GET("/api/user/balance", handler.GetUserBalance)
-	routes.POST("/api/user/balance/withdraw", handler.BalanceWithdraw)
+	routes.POST("/api/user/balance/withdraw", idempotencyMiddleware, handler.BalanceWithdraw)
+	routes.GET("/api/user/balance/withdrawals", handler.Withdrawals)
+	// the path used before the specification one, kept for the old clients
 	routes.GET("/api/user/withdrawals", handler.Withdrawals)
 }
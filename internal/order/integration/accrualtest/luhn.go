@@ -0,0 +1,20 @@
+package accrualtest
+
+import "strconv"
+
+// LuhnNumber appends the Luhn check digit to base, so tests get valid order numbers.
+func LuhnNumber(base int) string {
+	digits := strconv.Itoa(base)
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return digits + strconv.Itoa((10-sum%10)%10)
+}
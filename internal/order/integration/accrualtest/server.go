@@ -0,0 +1,168 @@
+// Package accrualtest provides an in-process fake of the accrual system.
+package accrualtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Step is a single scripted answer of the fake accrual system.
+type Step struct {
+	StatusCode int
+	Status     string
+	Accrual    string
+	RetryAfter string
+	Body       string
+	Delay      time.Duration
+}
+
+func Registered() Step {
+	return Step{StatusCode: http.StatusOK, Status: "REGISTERED"}
+}
+
+func Processing() Step {
+	return Step{StatusCode: http.StatusOK, Status: "PROCESSING"}
+}
+
+func Processed(accrual string) Step {
+	return Step{StatusCode: http.StatusOK, Status: "PROCESSED", Accrual: accrual}
+}
+
+func Invalid() Step {
+	return Step{StatusCode: http.StatusOK, Status: "INVALID"}
+}
+
+func NotRegistered() Step {
+	return Step{StatusCode: http.StatusNoContent}
+}
+
+// TooManyRequests answers 429 the way the real accrual system does.
+// Zero requestsPerMinute leaves the body empty.
+func TooManyRequests(retryAfter string, requestsPerMinute int) Step {
+	step := Step{StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter}
+	if requestsPerMinute > 0 {
+		step.Body = fmt.Sprintf("No more than %d requests per minute allowed", requestsPerMinute)
+	}
+	return step
+}
+
+func InternalError() Step {
+	return Step{StatusCode: http.StatusInternalServerError}
+}
+
+type orderResponse struct {
+	Order   string          `json:"order"`
+	Status  string          `json:"status"`
+	Accrual json.RawMessage `json:"accrual,omitempty"`
+}
+
+// Server answers GET /api/orders/{number} with the scripted steps of the order
+// one by one, the last step is repeated. Orders without a script get 204.
+type Server struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	scripts  map[string][]Step
+	requests map[string]int
+	fallback *Step
+}
+
+func NewServer() *Server {
+	s := &Server{
+		scripts:  make(map[string][]Step),
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Script replaces the answers for the order.
+func (s *Server) Script(number string, steps ...Step) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.scripts[number] = steps
+}
+
+// Override answers every request with step until Reset, e.g. to simulate an outage.
+func (s *Server) Override(step Step) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.fallback = &step
+}
+
+func (s *Server) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.fallback = nil
+}
+
+// Requests returns how many times the order was requested.
+func (s *Server) Requests(number string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests[number]
+}
+
+func (s *Server) next(number string) Step {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests[number]++
+	if s.fallback != nil {
+		return *s.fallback
+	}
+
+	steps := s.scripts[number]
+	if len(steps) == 0 {
+		return NotRegistered()
+	}
+	step := steps[0]
+	if len(steps) > 1 {
+		s.scripts[number] = steps[1:]
+	}
+	return step
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+	if r.Method != http.MethodGet || number == r.URL.Path || number == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	step := s.next(number)
+	if step.Delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(step.Delay):
+		}
+	}
+	if step.RetryAfter != "" {
+		w.Header().Set("Retry-After", step.RetryAfter)
+	}
+
+	if step.StatusCode != http.StatusOK {
+		w.WriteHeader(step.StatusCode)
+		if step.Body != "" {
+			_, _ = w.Write([]byte(step.Body))
+		}
+		return
+	}
+
+	response := orderResponse{Order: number, Status: step.Status}
+	if step.Accrual != "" {
+		response.Accrual = json.RawMessage(step.Accrual)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
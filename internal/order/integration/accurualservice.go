@@ -2,10 +2,9 @@ package integration
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"strconv"
+	"net/url"
 	"sync"
 	"time"
 
@@ -14,116 +13,265 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-type Order struct {
-	Number  string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float32 `json:"accrual"`
+// UpdateResult is the outcome of a single poll of the accrual system.
+// RetryAfter is set when the accrual system asked to wait.
+type UpdateResult struct {
+	Finalized  bool
+	RetryAfter time.Duration
 }
 
 type AccurualService struct {
-	AccrualSystemAddress string
-	OrderUseCase         order.UseCase
-	WaitGroup            *sync.WaitGroup
-	UpdateChannel        chan *string
+	Client         AccrualClient
+	OrderUseCase   order.UseCase
+	Queue          order.AccrualQueue
+	WaitGroup      *sync.WaitGroup
+	WorkerCount    int
+	PollInterval   time.Duration
+	JobLease       time.Duration
+	RetryPolicy    RetryPolicy
+	RateLimiter    *RateLimiter
+	CircuitBreaker *CircuitBreaker
+
+	stop context.CancelFunc
 }
 
-func NewAccurualService(wg *sync.WaitGroup, uc chan *string, address string, usecase order.UseCase) *AccurualService {
+func NewAccurualService(wg *sync.WaitGroup, client AccrualClient, usecase order.UseCase, queue order.AccrualQueue,
+	workerCount int, pollInterval time.Duration, jobLease time.Duration, retryPolicy RetryPolicy,
+	rateLimiter *RateLimiter, circuitBreaker *CircuitBreaker) *AccurualService {
 	return &AccurualService{
-		AccrualSystemAddress: address,
-		OrderUseCase:         usecase,
-		WaitGroup:            wg,
-		UpdateChannel:        uc,
+		Client:         client,
+		OrderUseCase:   usecase,
+		Queue:          queue,
+		WaitGroup:      wg,
+		WorkerCount:    workerCount,
+		PollInterval:   pollInterval,
+		JobLease:       jobLease,
+		RetryPolicy:    retryPolicy,
+		RateLimiter:    rateLimiter,
+		CircuitBreaker: circuitBreaker,
 	}
 }
 
-func (as *AccurualService) StartUpdateWorker() {
-	workerCount := 3
-
-	for i := 0; i < workerCount; i++ {
-		as.WaitGroup.Add(1)
-		go as.updateWorker(as.WaitGroup, as.UpdateChannel)
+// Stats is published as expvar metrics.
+func (as *AccurualService) Stats() interface{} {
+	return map[string]interface{}{
+		"circuit_breaker":     as.CircuitBreaker.Stats(),
+		"requests_per_minute": as.RateLimiter.RequestsPerMinute(),
 	}
-
 }
 
-func (as *AccurualService) updateWorker(wg *sync.WaitGroup, uChan <-chan *string) {
-	defer wg.Done()
+// StartUpdateWorker starts the workers. Cancelling ctx aborts jobs in flight,
+// use Shutdown to let them finish.
+func (as *AccurualService) StartUpdateWorker(ctx context.Context) {
+	stopCtx, stop := context.WithCancel(ctx)
+	as.stop = stop
 
-	for job := range uChan {
-		as.UpdateData(*job)
+	for i := 0; i < as.WorkerCount; i++ {
+		as.WaitGroup.Add(1)
+		go as.updateWorker(ctx, stopCtx, as.WaitGroup)
 	}
 }
 
-func (as *AccurualService) UpdateData(number string) error {
-	logger := log.With().Str("package", "integration").Str("function", "UpdateData").Logger()
+// Shutdown stops taking new jobs and waits for the jobs in flight
+// until ctx is done.
+func (as *AccurualService) Shutdown(ctx context.Context) error {
+	logger := log.With().Str("package", "integration").Str("function", "Shutdown").Logger()
 	logger.Debug().Msg("enter")
 	defer logger.Debug().Msg("exit")
 
-	var result Order
+	if as.stop != nil {
+		as.stop()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		as.WaitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (as *AccurualService) updateWorker(ctx context.Context, stopCtx context.Context, wg *sync.WaitGroup) {
+	logger := log.With().Str("package", "integration").Str("function", "updateWorker").Logger()
+	defer wg.Done()
+
 	for {
-		response, err := http.Get(fmt.Sprintf("%s/api/orders/%s", as.AccrualSystemAddress, number))
-		if err != nil {
-			logger.Debug().Err(err)
+		if stopCtx.Err() != nil {
+			return
+		}
+
+		// do not lease jobs while the accrual system is considered down
+		if delay := as.CircuitBreaker.RetryIn(); delay > 0 {
+			select {
+			case <-stopCtx.Done():
+				return
+			case <-time.After(delay):
+			}
 			continue
 		}
-		defer response.Body.Close()
 
-		if response.StatusCode == http.StatusInternalServerError {
-			return nil
+		job, err := as.Queue.Dequeue(ctx, as.JobLease)
+		if err != nil {
+			logger.Debug().Err(err).Msg("dequeue error")
 		}
 
-		if response.StatusCode == http.StatusTooManyRequests {
-			timeSleepString := response.Header.Get("Retry-After")
-			timeSleep, err := strconv.Atoi(timeSleepString)
-			logger.Debug().Str("Retry-After", timeSleepString).Msg("catch timeout")
-			logger.Debug().Err(err).Msg("error message")
-			if err != nil {
-				continue
+		if job == nil {
+			select {
+			case <-stopCtx.Done():
+				return
+			case <-time.After(as.PollInterval):
 			}
-			logger.Debug().Int("timeSleep", timeSleep).Msg("wait a some time")
-			time.Sleep(time.Duration(timeSleep) * time.Second)
 			continue
 		}
 
-		if response.StatusCode == http.StatusOK {
-			err = json.NewDecoder(response.Body).Decode(&result)
-			if err != nil {
-				return err
-			}
+		as.handleJob(ctx, stopCtx, job)
+	}
+}
 
-			logger.Debug().Str("response.Status", response.Status).Str("Number", result.Number).Str("Status", result.Status).Float32("Accurual", result.Accrual).Msg("get order")
-
-			/*
-				REGISTERED — заказ зарегистрирован, но не начисление не рассчитано;
-				INVALID — заказ не принят к расчёту, и вознаграждение не будет начислено;
-				PROCESSING — расчёт начисления в процессе;
-				PROCESSED — расчёт начисления окончен
-			*/
-
-			var status string
-			switch result.Status {
-			case "REGISTERED":
-				status = models.OrderStatusProcessing
-			case "INVALID":
-				status = models.OrderStatusInvalid
-			case "PROCESSING":
-				status = models.OrderStatusProcessing
-			case "PROCESSED":
-				status = models.OrderStatusProcessed
-			}
+func (as *AccurualService) handleJob(ctx context.Context, stopCtx context.Context, job *models.AccrualJob) {
+	logger := log.With().Str("package", "integration").Str("function", "handleJob").Logger()
 
-			if status == "" {
-				continue
-			}
+	if err := as.RateLimiter.Wait(stopCtx); err != nil {
+		// give the job back instead of waiting for the lease to expire
+		if err := as.Queue.Postpone(ctx, job.OrderNumber, 0, err.Error()); err != nil {
+			logger.Debug().Err(err).Str("orderNumber", job.OrderNumber).Msg("retry job error")
+		}
+		return
+	}
 
-			if result.Status == models.OrderStatusProcessing {
-				as.OrderUseCase.UpdateOrder(context.Background(), result.Number, status, 0)
-			}
-			if result.Status == models.OrderStatusProcessed ||
-				result.Status == models.OrderStatusInvalid {
-				as.OrderUseCase.UpdateOrder(context.Background(), result.Number, status, int32(result.Accrual*100))
-				return nil
-			}
+	result, err := as.UpdateData(ctx, job.OrderNumber)
+	if err == nil && result.Finalized {
+		if err := as.Queue.Complete(ctx, job.OrderNumber); err != nil {
+			logger.Debug().Err(err).Str("orderNumber", job.OrderNumber).Msg("complete job error")
+		}
+		return
+	}
+
+	var reason string
+	if err != nil {
+		reason = err.Error()
+	}
+
+	// throttled and short-circuited polls did not reach the accrual system and
+	// a not registered order is not a failure, so they do not use up the
+	// attempts; the elapsed time still limits them
+	postponed := errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrTooManyRequests) ||
+		errors.Is(err, ErrOrderNotRegistered)
+	if postponed {
+		job.Attempts--
+	}
+
+	if as.RetryPolicy.Exhausted(job, time.Now()) {
+		logger.Warn().Str("orderNumber", job.OrderNumber).Int("attempts", job.Attempts).Str("reason", reason).Msg("retry budget exhausted")
+		if err := as.OrderUseCase.MarkOrderStuck(ctx, job.OrderNumber, reason); err != nil {
+			logger.Debug().Err(err).Str("orderNumber", job.OrderNumber).Msg("mark order stuck error")
+			return
+		}
+		if err := as.Queue.Complete(ctx, job.OrderNumber); err != nil {
+			logger.Debug().Err(err).Str("orderNumber", job.OrderNumber).Msg("complete job error")
+		}
+		return
+	}
+
+	delay := result.RetryAfter
+	if errors.Is(err, ErrCircuitOpen) {
+		delay = as.CircuitBreaker.RetryIn() + as.PollInterval
+	}
+	if delay == 0 {
+		delay = as.RetryPolicy.NextDelay(job.Attempts)
+	}
+	reschedule := as.Queue.Retry
+	if postponed {
+		reschedule = as.Queue.Postpone
+	}
+	if err := reschedule(ctx, job.OrderNumber, delay, reason); err != nil {
+		logger.Debug().Err(err).Str("orderNumber", job.OrderNumber).Msg("retry job error")
+	}
+}
+
+// UpdateData polls the accrual system once for the order.
+func (as *AccurualService) UpdateData(ctx context.Context, number string) (UpdateResult, error) {
+	logger := log.With().Str("package", "integration").Str("function", "UpdateData").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	var updateResult UpdateResult
+
+	if !as.CircuitBreaker.Allow() {
+		return updateResult, ErrCircuitOpen
+	}
+
+	result, err := as.Client.GetOrder(ctx, number)
+
+	var urlError *url.Error
+	switch {
+	case ctx.Err() != nil:
+		as.CircuitBreaker.Cancel()
+	case errors.As(err, &urlError), errors.Is(err, ErrAccrualUnavailable):
+		as.CircuitBreaker.Failure()
+	default:
+		as.CircuitBreaker.Success()
+	}
+
+	var tooManyRequests *TooManyRequestsError
+	if errors.As(err, &tooManyRequests) {
+		updateResult.RetryAfter = tooManyRequests.RetryAfter
+		as.RateLimiter.Pause(tooManyRequests.RetryAfter)
+		if tooManyRequests.RequestsPerMinute > 0 {
+			logger.Debug().Int("requestsPerMinute", tooManyRequests.RequestsPerMinute).Msg("learn rate limit")
+			as.RateLimiter.SetRequestsPerMinute(tooManyRequests.RequestsPerMinute)
 		}
 	}
+	if err != nil {
+		return updateResult, err
+	}
+
+	/*
+		REGISTERED — заказ зарегистрирован, но не начисление не рассчитано;
+		INVALID — заказ не принят к расчёту, и вознаграждение не будет начислено;
+		PROCESSING — расчёт начисления в процессе;
+		PROCESSED — расчёт начисления окончен
+	*/
+
+	var status string
+	switch result.Status {
+	case AccrualStatusRegistered, AccrualStatusProcessing:
+		status = models.OrderStatusProcessing
+	case AccrualStatusInvalid:
+		status = models.OrderStatusInvalid
+	case AccrualStatusProcessed:
+		status = models.OrderStatusProcessed
+	default:
+		return updateResult, fmt.Errorf("%w: %s", ErrUnknownStatus, result.Status)
+	}
+
+	var accrual models.Money
+	if status == models.OrderStatusProcessed && result.Accrual != "" {
+		// the accrual system is not bound to our precision, so round instead of rejecting
+		accrual, err = models.RoundMoney(result.Accrual.String())
+		if err != nil {
+			return updateResult, err
+		}
+	}
+
+	err = as.OrderUseCase.UpdateOrder(ctx, number, status, accrual)
+	var transitionError *order.TransitionError
+	if errors.As(err, &transitionError) && order.IsFinalStatus(transitionError.From) {
+		// the order was finalized already, there is nothing to poll for
+		logger.Debug().Err(err).Str("orderNumber", number).Msg("order already finalized")
+		updateResult.Finalized = true
+		return updateResult, nil
+	}
+	if err != nil {
+		return updateResult, err
+	}
+	updateResult.Finalized = order.IsFinalStatus(status)
+
+	return updateResult, nil
 }
@@ -0,0 +1,256 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/alexkopcak/gophermart/internal/order"
+	"github.com/alexkopcak/gophermart/internal/order/integration"
+	"github.com/alexkopcak/gophermart/internal/order/integration/accrualtest"
+	"github.com/alexkopcak/gophermart/internal/order/repository/localstorage"
+	"github.com/alexkopcak/gophermart/internal/order/usecase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testUserID    int32 = 1
+	waitFor             = 5 * time.Second
+	checkInterval       = 5 * time.Millisecond
+)
+
+type testEnv struct {
+	server  *accrualtest.Server
+	ouc     order.UseCase
+	service *integration.AccurualService
+}
+
+func newTestEnv(t *testing.T, retryPolicy integration.RetryPolicy, circuitBreaker *integration.CircuitBreaker) *testEnv {
+	server := accrualtest.NewServer()
+	t.Cleanup(server.Close)
+
+	queue := localstorage.NewAccrualQueueLocalStorage()
+	ouc := usecase.NewOrderUseCase(localstorage.NewOrderLocalStorage(), queue)
+
+	if retryPolicy.InitialInterval == 0 {
+		retryPolicy.InitialInterval = checkInterval
+		retryPolicy.Multiplier = 1
+	}
+	if circuitBreaker == nil {
+		circuitBreaker = integration.NewCircuitBreaker(100, time.Second)
+	}
+
+	wg := &sync.WaitGroup{}
+	service := integration.NewAccurualService(wg,
+		integration.NewHTTPAccrualClient(server.URL, &http.Client{Timeout: time.Second}),
+		ouc, queue, 2, checkInterval, time.Second, retryPolicy,
+		integration.NewRateLimiter(0, 1), circuitBreaker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	service.StartUpdateWorker(ctx)
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	return &testEnv{server: server, ouc: ouc, service: service}
+}
+
+func (env *testEnv) order(t *testing.T, number string) models.Order {
+	page, err := env.ouc.GetOrders(context.Background(), testUserID, &order.ListFilter{})
+	require.NoError(t, err)
+	for _, item := range page.Orders {
+		if item.Number == number {
+			return item
+		}
+	}
+	t.Fatalf("order %s not found", number)
+	return models.Order{}
+}
+
+func (env *testEnv) waitStatus(t *testing.T, number string, status string) {
+	assert.Eventually(t, func() bool {
+		return env.order(t, number).Status == status
+	}, waitFor, checkInterval)
+}
+
+func TestAccrualLifecycleProcessed(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{}, nil)
+	number := accrualtest.LuhnNumber(1001)
+	env.server.Script(number,
+		accrualtest.Registered(),
+		accrualtest.Processing(),
+		accrualtest.Processed("729.98"))
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	env.waitStatus(t, number, models.OrderStatusProcessed)
+
+	assert.Equal(t, models.Money(72998), env.order(t, number).Accrual)
+	balance, err := env.ouc.GetBalance(context.Background(), testUserID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(72998), balance.Current)
+	assert.Equal(t, 3, env.server.Requests(number))
+
+	// the finalized order is not polled any more
+	time.Sleep(10 * checkInterval)
+	assert.Equal(t, 3, env.server.Requests(number))
+}
+
+func TestAccrualLifecycleInvalid(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{}, nil)
+	number := accrualtest.LuhnNumber(1002)
+	env.server.Script(number, accrualtest.Processing(), accrualtest.Invalid())
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	env.waitStatus(t, number, models.OrderStatusInvalid)
+
+	balance, err := env.ouc.GetBalance(context.Background(), testUserID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(0), balance.Current)
+}
+
+func TestAccrualLifecycleNotRegisteredYet(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{}, nil)
+	number := accrualtest.LuhnNumber(1003)
+	env.server.Script(number,
+		accrualtest.NotRegistered(),
+		accrualtest.NotRegistered(),
+		accrualtest.Processed("100"))
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	env.waitStatus(t, number, models.OrderStatusProcessed)
+	assert.Equal(t, models.Money(10000), env.order(t, number).Accrual)
+}
+
+func TestAccrualLifecycleRegistered(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{}, nil)
+	number := accrualtest.LuhnNumber(1009)
+	env.server.Script(number, accrualtest.Registered())
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	env.waitStatus(t, number, models.OrderStatusProcessing)
+}
+
+func TestAccrualFinalizedOrderIsNotReverted(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{}, nil)
+	number := accrualtest.LuhnNumber(1010)
+	env.server.Script(number, accrualtest.Processed("10"))
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	env.waitStatus(t, number, models.OrderStatusProcessed)
+
+	env.server.Script(number, accrualtest.Processing())
+	result, err := env.service.UpdateData(context.Background(), number)
+	require.NoError(t, err)
+	assert.True(t, result.Finalized)
+	assert.Equal(t, models.OrderStatusProcessed, env.order(t, number).Status)
+	assert.Equal(t, models.Money(1000), env.order(t, number).Accrual)
+}
+
+func TestAccrualLifecycleThrottledPollsKeepAttempts(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{MaxAttempts: 2}, nil)
+	number := accrualtest.LuhnNumber(1011)
+	env.server.Script(number,
+		accrualtest.NotRegistered(),
+		accrualtest.TooManyRequests("0", 0),
+		accrualtest.NotRegistered(),
+		accrualtest.Processed("7"))
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	env.waitStatus(t, number, models.OrderStatusProcessed)
+	assert.Equal(t, 4, env.server.Requests(number))
+}
+
+func TestAccrualLifecycleTooManyRequests(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{}, nil)
+	number := accrualtest.LuhnNumber(1004)
+	env.server.Script(number,
+		accrualtest.TooManyRequests("1", 120),
+		accrualtest.Processed("5"))
+
+	start := time.Now()
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	env.waitStatus(t, number, models.OrderStatusProcessed)
+
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	assert.Equal(t, 120, env.service.RateLimiter.RequestsPerMinute())
+	assert.Equal(t, 2, env.server.Requests(number))
+}
+
+func TestAccrualLifecycleOutage(t *testing.T) {
+	circuitBreaker := integration.NewCircuitBreaker(2, 50*time.Millisecond)
+	env := newTestEnv(t, integration.RetryPolicy{}, circuitBreaker)
+	number := accrualtest.LuhnNumber(1005)
+	env.server.Script(number, accrualtest.Processed("1.5"))
+	env.server.Override(accrualtest.InternalError())
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	assert.Eventually(t, func() bool {
+		return circuitBreaker.State() == integration.CircuitOpen
+	}, waitFor, checkInterval)
+
+	env.server.Reset()
+	env.waitStatus(t, number, models.OrderStatusProcessed)
+	assert.Equal(t, integration.CircuitClosed, circuitBreaker.State())
+	assert.Equal(t, models.Money(150), env.order(t, number).Accrual)
+}
+
+func TestAccrualLifecycleStuck(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{MaxAttempts: 3}, nil)
+	number := accrualtest.LuhnNumber(1006)
+	env.server.Script(number, accrualtest.InternalError())
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	assert.Eventually(t, func() bool {
+		orders, err := env.ouc.GetNotFinnalizedOrdersList(context.Background())
+		return err == nil && len(orders) == 0
+	}, waitFor, checkInterval)
+
+	time.Sleep(10 * checkInterval)
+	assert.Equal(t, 3, env.server.Requests(number))
+	assert.Equal(t, models.OrderStatusNew, env.order(t, number).Status)
+	assert.True(t, env.order(t, number).Stuck)
+
+	details, err := env.ouc.GetOrder(context.Background(), testUserID, number)
+	require.NoError(t, err)
+	assert.True(t, details.Order.Stuck)
+}
+
+func TestAccrualShutdownWaitsForJobInFlight(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{}, nil)
+	number := accrualtest.LuhnNumber(1007)
+	step := accrualtest.Processed("10")
+	step.Delay = 200 * time.Millisecond
+	env.server.Script(number, step)
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	assert.Eventually(t, func() bool {
+		return env.server.Requests(number) == 1
+	}, waitFor, checkInterval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitFor)
+	defer cancel()
+	require.NoError(t, env.service.Shutdown(ctx))
+	assert.Equal(t, models.OrderStatusProcessed, env.order(t, number).Status)
+}
+
+func TestAccrualShutdownTimeout(t *testing.T) {
+	env := newTestEnv(t, integration.RetryPolicy{}, nil)
+	number := accrualtest.LuhnNumber(1008)
+	step := accrualtest.Processed("10")
+	step.Delay = waitFor
+	env.server.Script(number, step)
+
+	require.NoError(t, env.ouc.AddNewOrder(context.Background(), testUserID, number))
+	assert.Eventually(t, func() bool {
+		return env.server.Requests(number) == 1
+	}, waitFor, checkInterval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, env.service.Shutdown(ctx), context.DeadlineExceeded)
+}
@@ -0,0 +1,138 @@
+package integration
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops requests to the accrual system after FailureThreshold
+// consecutive failures. After OpenTimeout a single probe request is let
+// through, its result closes or opens the circuit again.
+type CircuitBreaker struct {
+	mutex            sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+	state            string
+	failures         int
+	openedAt         time.Time
+	probing          bool
+	trips            int64
+	rejected         int64
+	now              func() time.Time
+}
+
+type CircuitBreakerStats struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Trips               int64  `json:"trips"`
+	Rejected            int64  `json:"rejected"`
+}
+
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		state:            CircuitClosed,
+		now:              time.Now,
+	}
+}
+
+// Allow reports whether a request may be sent now. In the half-open state
+// only one request is allowed until its result is reported.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.openTimeout {
+			cb.rejected++
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		cb.probing = true
+		return true
+	case CircuitHalfOpen:
+		if cb.probing {
+			cb.rejected++
+			return false
+		}
+		cb.probing = true
+		return true
+	}
+	return true
+}
+
+// RetryIn returns how long the circuit stays open, zero when requests are allowed.
+func (cb *CircuitBreaker) RetryIn() time.Duration {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state != CircuitOpen {
+		return 0
+	}
+	if delay := cb.openTimeout - cb.now().Sub(cb.openedAt); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.state = CircuitClosed
+	cb.failures = 0
+	cb.probing = false
+}
+
+// Cancel gives back an allowed request that got no answer, e.g. on shutdown.
+func (cb *CircuitBreaker) Cancel() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.probing = false
+}
+
+func (cb *CircuitBreaker) Failure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.failures++
+	cb.probing = false
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.failureThreshold {
+		if cb.state != CircuitOpen {
+			cb.trips++
+		}
+		cb.state = CircuitOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+func (cb *CircuitBreaker) State() string {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.state
+}
+
+func (cb *CircuitBreaker) Stats() CircuitBreakerStats {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return CircuitBreakerStats{
+		State:               cb.state,
+		ConsecutiveFailures: cb.failures,
+		Trips:               cb.trips,
+		Rejected:            cb.rejected,
+	}
+}
@@ -0,0 +1,80 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCircuitBreaker(failureThreshold int, openTimeout time.Duration) (*CircuitBreaker, *time.Time) {
+	now := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+	cb := NewCircuitBreaker(failureThreshold, openTimeout)
+	cb.now = func() time.Time { return now }
+	return cb, &now
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, cb.Allow())
+		cb.Failure()
+	}
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	// a success resets the counter
+	assert.True(t, cb.Allow())
+	cb.Success()
+	for i := 0; i < 2; i++ {
+		cb.Failure()
+	}
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	cb.Failure()
+	assert.Equal(t, CircuitOpen, cb.State())
+	assert.False(t, cb.Allow())
+	assert.Equal(t, time.Minute, cb.RetryIn())
+
+	stats := cb.Stats()
+	assert.Equal(t, int64(1), stats.Trips)
+	assert.Equal(t, int64(1), stats.Rejected)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cb, now := newTestCircuitBreaker(1, time.Minute)
+
+	cb.Failure()
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	*now = now.Add(time.Minute)
+	assert.Zero(t, cb.RetryIn())
+
+	// only one probe goes through
+	assert.True(t, cb.Allow())
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	assert.False(t, cb.Allow())
+
+	cb.Failure()
+	assert.Equal(t, CircuitOpen, cb.State())
+	assert.Equal(t, int64(2), cb.Stats().Trips)
+
+	*now = now.Add(time.Minute)
+	assert.True(t, cb.Allow())
+	cb.Success()
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.True(t, cb.Allow())
+	assert.True(t, cb.Allow())
+}
+
+func TestCircuitBreakerCancelReleasesProbe(t *testing.T) {
+	cb, now := newTestCircuitBreaker(1, time.Minute)
+
+	cb.Failure()
+	*now = now.Add(time.Minute)
+	assert.True(t, cb.Allow())
+	cb.Cancel()
+
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	assert.True(t, cb.Allow())
+}
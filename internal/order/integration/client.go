@@ -0,0 +1,113 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AccrualClient asks the accrual system about a single order.
+// Besides transport errors it returns ErrOrderNotRegistered,
+// *TooManyRequestsError and errors wrapping ErrAccrualUnavailable.
+type AccrualClient interface {
+	GetOrder(ctx context.Context, number string) (*Order, error)
+}
+
+// statuses of the accrual system, they differ from the order statuses
+const (
+	AccrualStatusRegistered = "REGISTERED"
+	AccrualStatusInvalid    = "INVALID"
+	AccrualStatusProcessing = "PROCESSING"
+	AccrualStatusProcessed  = "PROCESSED"
+)
+
+type Order struct {
+	Number  string      `json:"order"`
+	Status  string      `json:"status"`
+	Accrual json.Number `json:"accrual"`
+}
+
+// TooManyRequestsError is returned on 429. RetryAfter and RequestsPerMinute
+// are zero when the accrual system did not send them.
+type TooManyRequestsError struct {
+	RetryAfter        time.Duration
+	RequestsPerMinute int
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return ErrTooManyRequests.Error()
+}
+
+func (e *TooManyRequestsError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+type HTTPAccrualClient struct {
+	address string
+	client  *http.Client
+}
+
+func NewHTTPAccrualClient(address string, client *http.Client) AccrualClient {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &HTTPAccrualClient{
+		address: strings.TrimRight(address, "/"),
+		client:  client,
+	}
+}
+
+func (hac *HTTPAccrualClient) GetOrder(ctx context.Context, number string) (*Order, error) {
+	logger := log.With().Str("package", "integration").Str("function", "GetOrder").Logger()
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/api/orders/%s", hac.address, url.PathEscape(number)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := hac.client.Do(request)
+	if err != nil {
+		logger.Debug().Err(err).Msg("request error")
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusOK:
+	case response.StatusCode == http.StatusNoContent:
+		return nil, ErrOrderNotRegistered
+	case response.StatusCode == http.StatusTooManyRequests:
+		retryAfter := response.Header.Get("Retry-After")
+		logger.Debug().Str("Retry-After", retryAfter).Msg("catch timeout")
+
+		result := &TooManyRequestsError{}
+		result.RetryAfter, _ = parseRetryAfter(retryAfter, time.Now())
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		result.RequestsPerMinute, _ = parseRateLimit(string(body))
+		return nil, result
+	case response.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: %s", ErrAccrualUnavailable, response.Status)
+	default:
+		return nil, fmt.Errorf("accrual system responded with %s", response.Status)
+	}
+
+	var result Order
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug().Str("response.Status", response.Status).Str("Number", result.Number).Str("Status", result.Status).Str("Accurual", result.Accrual.String()).Msg("get order")
+	return &result, nil
+}
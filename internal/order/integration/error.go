@@ -0,0 +1,11 @@
+package integration
+
+import "errors"
+
+var (
+	ErrOrderNotRegistered = errors.New("order is not registered in the accrual system")
+	ErrTooManyRequests    = errors.New("too many requests to the accrual system")
+	ErrUnknownStatus      = errors.New("unknown accrual status")
+	ErrAccrualUnavailable = errors.New("accrual system is unavailable")
+	ErrCircuitOpen        = errors.New("accrual system circuit breaker is open")
+)
@@ -0,0 +1,145 @@
+package integration
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var rateLimitMessage = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+// RateLimiter is a token bucket shared by all accrual workers.
+// Zero limit means no limit until one is learned from the accrual system.
+type RateLimiter struct {
+	mutex       sync.Mutex
+	limit       float64 // tokens per second
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+func NewRateLimiter(requestsPerMinute int, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	rl := &RateLimiter{
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+	rl.last = rl.now()
+	rl.SetRequestsPerMinute(requestsPerMinute)
+	return rl
+}
+
+// Wait blocks until a request is allowed or ctx is done.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		delay := rl.reserve()
+		if delay == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token and returns zero, or returns how long to wait before trying again.
+func (rl *RateLimiter) reserve() time.Duration {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	if now.Before(rl.pausedUntil) {
+		return rl.pausedUntil.Sub(now)
+	}
+
+	if rl.limit == 0 {
+		return 0
+	}
+
+	rl.refill(now)
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return 0
+	}
+
+	delay := time.Duration((1 - rl.tokens) / rl.limit * float64(time.Second))
+	if delay <= 0 {
+		delay = time.Millisecond
+	}
+	return delay
+}
+
+func (rl *RateLimiter) refill(now time.Time) {
+	if !now.After(rl.last) {
+		return
+	}
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.limit
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+}
+
+// Pause stops every worker for d. Tokens are dropped so that workers
+// do not burst right after the pause.
+func (rl *RateLimiter) Pause(d time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	if until := now.Add(d); until.After(rl.pausedUntil) {
+		rl.pausedUntil = until
+	}
+	rl.tokens = 0
+	rl.last = rl.pausedUntil
+}
+
+func (rl *RateLimiter) SetRequestsPerMinute(requestsPerMinute int) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	if rl.limit > 0 {
+		rl.refill(now)
+	} else if now.After(rl.last) {
+		rl.last = now
+	}
+	if requestsPerMinute < 0 {
+		requestsPerMinute = 0
+	}
+	rl.limit = float64(requestsPerMinute) / 60
+}
+
+func (rl *RateLimiter) RequestsPerMinute() int {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	return int(rl.limit*60 + 0.5)
+}
+
+// parseRateLimit extracts the allowed number of requests per minute
+// from the 429 response body of the accrual system.
+func parseRateLimit(body string) (int, bool) {
+	match := rateLimitMessage.FindStringSubmatch(body)
+	if match == nil {
+		return 0, false
+	}
+
+	requestsPerMinute, err := strconv.Atoi(match[1])
+	if err != nil || requestsPerMinute <= 0 {
+		return 0, false
+	}
+	return requestsPerMinute, true
+}
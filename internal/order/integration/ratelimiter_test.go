@@ -0,0 +1,90 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRateLimiter(requestsPerMinute int, burst int) (*RateLimiter, *time.Time) {
+	now := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter(0, burst)
+	rl.now = func() time.Time { return now }
+	rl.last = now
+	rl.SetRequestsPerMinute(requestsPerMinute)
+	return rl, &now
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	rl, _ := newTestRateLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		assert.Zero(t, rl.reserve())
+	}
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	rl, now := newTestRateLimiter(60, 2)
+
+	assert.Zero(t, rl.reserve())
+	assert.Zero(t, rl.reserve())
+	assert.Equal(t, time.Second, rl.reserve())
+
+	*now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, 500*time.Millisecond, rl.reserve())
+
+	*now = now.Add(10 * time.Second)
+	assert.Zero(t, rl.reserve())
+	assert.Zero(t, rl.reserve())
+	assert.NotZero(t, rl.reserve())
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	rl, now := newTestRateLimiter(0, 1)
+
+	rl.Pause(time.Minute)
+	assert.Equal(t, time.Minute, rl.reserve())
+
+	// a shorter pause does not cut the current one
+	rl.Pause(time.Second)
+	assert.Equal(t, time.Minute, rl.reserve())
+
+	*now = now.Add(time.Minute)
+	assert.Zero(t, rl.reserve())
+}
+
+func TestRateLimiterLearnedLimitAfterPause(t *testing.T) {
+	rl, now := newTestRateLimiter(0, 1)
+
+	rl.Pause(time.Minute)
+	rl.SetRequestsPerMinute(60)
+	assert.Equal(t, 60, rl.RequestsPerMinute())
+
+	*now = now.Add(time.Minute)
+	assert.Equal(t, time.Second, rl.reserve())
+
+	*now = now.Add(time.Second)
+	assert.Zero(t, rl.reserve())
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	rl := NewRateLimiter(0, 1)
+	rl.Pause(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, rl.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestParseRateLimit(t *testing.T) {
+	requestsPerMinute, ok := parseRateLimit("No more than 10 requests per minute allowed")
+	assert.True(t, ok)
+	assert.Equal(t, 10, requestsPerMinute)
+
+	_, ok = parseRateLimit("too many requests")
+	assert.False(t, ok)
+
+	_, ok = parseRateLimit("No more than 0 requests per minute allowed")
+	assert.False(t, ok)
+}
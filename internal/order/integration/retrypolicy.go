@@ -0,0 +1,75 @@
+package integration
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+)
+
+// RetryPolicy describes how often a not finalized order is polled again.
+// Zero MaxAttempts or MaxElapsedTime means no limit.
+type RetryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	Jitter          float64
+	MaxAttempts     int
+	MaxElapsedTime  time.Duration
+}
+
+// NextDelay returns the delay after the given attempt (starting from one),
+// randomized by ±Jitter.
+func (rp RetryPolicy) NextDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := float64(rp.InitialInterval) * math.Pow(rp.Multiplier, float64(attempt-1))
+	if rp.MaxInterval > 0 && delay > float64(rp.MaxInterval) {
+		delay = float64(rp.MaxInterval)
+	}
+
+	if rp.Jitter > 0 {
+		delay += delay * rp.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+func (rp RetryPolicy) Exhausted(job *models.AccrualJob, now time.Time) bool {
+	if rp.MaxAttempts > 0 && job.Attempts >= rp.MaxAttempts {
+		return true
+	}
+	if rp.MaxElapsedTime > 0 && now.Sub(job.CreatedAt) >= rp.MaxElapsedTime {
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter supports both forms of the header: delay in seconds and HTTP-date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
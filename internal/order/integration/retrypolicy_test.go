@@ -0,0 +1,68 @@
+package integration
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyNextDelay(t *testing.T) {
+	rp := RetryPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     10 * time.Second,
+		Multiplier:      2,
+	}
+
+	assert.Equal(t, time.Second, rp.NextDelay(0))
+	assert.Equal(t, time.Second, rp.NextDelay(1))
+	assert.Equal(t, 2*time.Second, rp.NextDelay(2))
+	assert.Equal(t, 8*time.Second, rp.NextDelay(4))
+	assert.Equal(t, 10*time.Second, rp.NextDelay(5))
+	assert.Equal(t, 10*time.Second, rp.NextDelay(100))
+
+	rp.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		delay := rp.NextDelay(3)
+		assert.GreaterOrEqual(t, delay, 3200*time.Millisecond)
+		assert.LessOrEqual(t, delay, 4800*time.Millisecond)
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	now := time.Now()
+	rp := RetryPolicy{MaxAttempts: 3, MaxElapsedTime: time.Hour}
+
+	assert.False(t, rp.Exhausted(&models.AccrualJob{Attempts: 2, CreatedAt: now}, now))
+	assert.True(t, rp.Exhausted(&models.AccrualJob{Attempts: 3, CreatedAt: now}, now))
+	assert.True(t, rp.Exhausted(&models.AccrualJob{Attempts: 1, CreatedAt: now.Add(-2 * time.Hour)}, now))
+
+	assert.False(t, RetryPolicy{}.Exhausted(&models.AccrualJob{Attempts: 1000, CreatedAt: now.Add(-1000 * time.Hour)}, now))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{name: "seconds", value: "60", delay: time.Minute, ok: true},
+		{name: "http date", value: now.Add(30 * time.Second).Format(http.TimeFormat), delay: 30 * time.Second, ok: true},
+		{name: "date in the past", value: now.Add(-time.Minute).Format(http.TimeFormat), delay: 0, ok: true},
+		{name: "empty", value: "", delay: 0, ok: false},
+		{name: "negative", value: "-1", delay: 0, ok: false},
+		{name: "garbage", value: "soon", delay: 0, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.delay, delay)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package order
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+)
+
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// Cursor points to the last item of a page, the next page starts right
+// after it in the sort order. The id breaks the ties of the time, which is
+// the upload time of an order or the processing time of a withdrawal.
+type Cursor struct {
+	Time time.Time
+	ID   int64
+}
+
+func ParseCursor(value string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrBadCursor
+	}
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || id <= 0 {
+		return nil, ErrBadCursor
+	}
+	return &Cursor{Time: time.UnixMicro(micros).UTC(), ID: id}, nil
+}
+
+func (c *Cursor) String() string {
+	value := strconv.FormatInt(c.Time.UnixMicro(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// ListFilter selects the orders of a user. Zero UploadedFrom and UploadedTo
+// do not limit the range, UploadedTo is exclusive. Zero Limit returns all
+// the orders, as the list did before the pages.
+type ListFilter struct {
+	Statuses     []string
+	UploadedFrom time.Time
+	UploadedTo   time.Time
+	Sort         string
+	Limit        int
+	After        *Cursor
+}
+
+// Validate fills the defaults and checks the filter.
+func (f *ListFilter) Validate() error {
+	if f.Sort == "" {
+		f.Sort = SortAsc
+	}
+	if f.Sort != SortAsc && f.Sort != SortDesc {
+		return ErrBadListFilter
+	}
+
+	if f.Limit == 0 && f.After != nil {
+		f.Limit = DefaultListLimit
+	}
+	if f.Limit < 0 || f.Limit > MaxListLimit {
+		return ErrBadListFilter
+	}
+
+	for _, status := range f.Statuses {
+		if !IsKnownStatus(status) {
+			return ErrBadListFilter
+		}
+	}
+
+	if !f.UploadedFrom.IsZero() && !f.UploadedTo.IsZero() && !f.UploadedFrom.Before(f.UploadedTo) {
+		return ErrBadListFilter
+	}
+	return nil
+}
+
+// OrdersPage is a page of orders, Next is nil on the last page.
+type OrdersPage struct {
+	Orders []models.Order
+	Next   *Cursor
+}
+
+// WithdrawalsFilter selects the withdrawals of a user in the processing time
+// order. Zero From and To do not limit the period, To is exclusive.
+// Zero Limit returns all the withdrawals.
+type WithdrawalsFilter struct {
+	From  time.Time
+	To    time.Time
+	Limit int
+	After *Cursor
+}
+
+func (f *WithdrawalsFilter) Validate() error {
+	if f.Limit == 0 && f.After != nil {
+		f.Limit = DefaultListLimit
+	}
+	if f.Limit < 0 || f.Limit > MaxListLimit {
+		return ErrBadListFilter
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return ErrBadListFilter
+	}
+	return nil
+}
+
+// WithdrawalsPage is a page of withdrawals, Next is nil on the last page.
+// Count and Total sum up the whole period regardless of the page.
+type WithdrawalsPage struct {
+	Withdrawals []*models.Withdrawals
+	Next        *Cursor
+	Count       int
+	Total       models.Money
+}
@@ -0,0 +1,45 @@
+package order
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := &Cursor{Time: time.Date(2022, 3, 1, 10, 0, 0, 123456000, time.UTC), ID: 42}
+
+	parsed, err := ParseCursor(cursor.String())
+	require.NoError(t, err)
+	assert.Equal(t, cursor, parsed)
+
+	for _, value := range []string{"", "not base64!", "MTIz", "YTox", "MTIzOjA"} {
+		_, err = ParseCursor(value)
+		assert.Equal(t, ErrBadCursor, err, value)
+	}
+}
+
+func TestListFilterValidate(t *testing.T) {
+	filter := &ListFilter{}
+	require.NoError(t, filter.Validate())
+	assert.Equal(t, SortAsc, filter.Sort)
+	// the list without a page is not cut
+	assert.Equal(t, 0, filter.Limit)
+
+	filter = &ListFilter{After: &Cursor{Time: time.Now(), ID: 1}}
+	require.NoError(t, filter.Validate())
+	assert.Equal(t, DefaultListLimit, filter.Limit)
+
+	now := time.Now()
+	for _, filter := range []*ListFilter{
+		{Sort: "up"},
+		{Limit: MaxListLimit + 1},
+		{Statuses: []string{models.OrderStatusNew, "DONE"}},
+		{UploadedFrom: now, UploadedTo: now},
+	} {
+		assert.Equal(t, ErrBadListFilter, filter.Validate())
+	}
+}
@@ -0,0 +1,21 @@
+package order
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+)
+
+// AccrualQueue keeps orders waiting for the accrual system. Dequeue leases
+// the job for the given time, so a job of a crashed worker is picked up
+// again once the lease is over; it returns nil when no job is ready.
+type AccrualQueue interface {
+	Enqueue(ctx context.Context, orderNumber string) error
+	Dequeue(ctx context.Context, lease time.Duration) (*models.AccrualJob, error)
+	Complete(ctx context.Context, orderNumber string) error
+	Retry(ctx context.Context, orderNumber string, delay time.Duration, reason string) error
+	// Postpone reschedules the job like Retry and gives back the attempt
+	// counted by Dequeue, the poll did not fail.
+	Postpone(ctx context.Context, orderNumber string, delay time.Duration, reason string) error
+}
@@ -2,17 +2,25 @@ package order
 
 import (
 	"context"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/models"
 )
 
+// OrderRepository.GetOrderByOrderUID returns nil without an error when the
+// order is not found. Every status an order gets is kept in its history.
 type OrderRepository interface {
 	InsertOrder(ctx context.Context, userID int32, orderNumber string) error
-	GetOrdersListByUserID(ctx context.Context, userID int32) ([]models.Order, error)
+	GetOrderByOrderUID(ctx context.Context, orderNumber string) (*models.Order, error)
+	GetOrderStatusHistory(ctx context.Context, orderNumber string) ([]*models.OrderStatusChange, error)
+	GetStatusLatency(ctx context.Context, since time.Time) (*LatencyReport, error)
+	GetOrdersListByUserID(ctx context.Context, userID int32, filter *ListFilter) (*OrdersPage, error)
 	GetBalanceByUserID(ctx context.Context, userID int32) (*models.Balance, error)
+	GetBalanceByUserIDAt(ctx context.Context, userID int32, at time.Time) (*models.Balance, error)
 	WithdrawBalance(ctx context.Context, userID int32, bw *models.BalanceWithdraw) error
-	Withdrawals(ctx context.Context, userID int32) ([]*models.Withdrawals, error)
-	UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual int32) error
+	Withdrawals(ctx context.Context, userID int32, filter *WithdrawalsFilter) (*WithdrawalsPage, error)
+	UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual models.Money) error
+	MarkOrderStuck(ctx context.Context, orderNumber string, reason string) error
 	GetNotFinnalizedOrdersListByUserID(ctx context.Context, userID int32) ([]*models.Order, error)
 	GetNotFinnalizedOrdersList(ctx context.Context) ([]*models.Order, error)
 }
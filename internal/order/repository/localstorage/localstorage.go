@@ -2,7 +2,13 @@ package localstorage
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/alexkopcak/gophermart/internal/ledger"
+	ledgerlocalstorage "github.com/alexkopcak/gophermart/internal/ledger/repository/localstorage"
 	"github.com/alexkopcak/gophermart/internal/models"
 	"github.com/alexkopcak/gophermart/internal/order"
 	"github.com/jackc/pgtype"
@@ -13,22 +19,37 @@ type OrderItem struct {
 	Number  string
 	Debet   bool
 	Status  string
-	Accrual int32
+	Accrual models.Money
 	Date    pgtype.Timestamp
+	Stuck   bool
+	History []*models.OrderStatusChange
 }
 
 type OrderLocalStorage struct {
-	order []OrderItem
+	order  []OrderItem
+	ledger ledger.Repository
+	mutex  *sync.Mutex
 }
 
 func NewOrderLocalStorage() order.OrderRepository {
+	return NewOrderLocalStorageWithLedger(ledgerlocalstorage.NewLedgerLocalStorage())
+}
+
+// NewOrderLocalStorageWithLedger shares the journal with the user storage,
+// which forfeits the points of deleted users.
+func NewOrderLocalStorageWithLedger(ledgerRepo ledger.Repository) order.OrderRepository {
 	return &OrderLocalStorage{
-		order: make([]OrderItem, 0),
+		order:  make([]OrderItem, 0),
+		ledger: ledgerRepo,
+		mutex:  new(sync.Mutex),
 	}
 }
 
 func (ols *OrderLocalStorage) InsertOrder(ctx context.Context, userID int32, orderNumber string) error {
-	orderItem, _ := ols.GetOrderByOrderUID(ctx, userID, orderNumber)
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
+	orderItem := ols.getOrder(orderNumber)
 	if orderItem != nil {
 		if orderItem.UserName == userID {
 			return order.ErrOrderAlreadyInsertedByUser
@@ -44,122 +65,311 @@ func (ols *OrderLocalStorage) InsertOrder(ctx context.Context, userID int32, ord
 		Debet:   true,
 		Status:  models.OrderStatusNew,
 		Accrual: 0,
-		Date:    pgtype.Timestamp{},
+		Date:    pgtype.Timestamp{Time: time.Now().UTC().Truncate(time.Microsecond), Status: pgtype.Present},
 	}
+	item.History = []*models.OrderStatusChange{{Status: item.Status, ChangedAt: item.Date.Time}}
 	ols.order = append(ols.order, item)
 	return nil
 }
 
-func (ols *OrderLocalStorage) GetOrdersListByUserID(ctx context.Context, userID int32) ([]models.Order, error) {
-	result := make([]models.Order, 0)
+// GetOrdersListByUserID uses the position of the order in the storage as its id.
+func (ols *OrderLocalStorage) GetOrdersListByUserID(ctx context.Context, userID int32, filter *order.ListFilter) (*order.OrdersPage, error) {
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
+	cursors := make([]order.Cursor, 0)
+	for i, item := range ols.order {
+		if item.UserID != userID || !item.Debet || !matchOrder(item, filter) {
+			continue
+		}
+		cursor := order.Cursor{Time: item.Date.Time, ID: int64(i + 1)}
+		if filter.After != nil && !cursorLess(*filter.After, cursor, filter.Sort) {
+			continue
+		}
+		cursors = append(cursors, cursor)
+	}
+	sort.Slice(cursors, func(i, j int) bool {
+		return cursorLess(cursors[i], cursors[j], filter.Sort)
+	})
+
+	result := &order.OrdersPage{Orders: make([]models.Order, 0)}
+	for i, cursor := range cursors {
+		if filter.Limit > 0 && i == filter.Limit {
+			result.Next = &cursors[i-1]
+			break
+		}
+		item := ols.order[cursor.ID-1]
+		result.Orders = append(result.Orders, models.Order{
+			UserName: item.UserID,
+			Number:   item.Number,
+			Status:   item.Status,
+			Accrual:  item.Accrual,
+			Stuck:    item.Stuck,
+			Uploaded: item.Date,
+		})
+	}
+	return result, nil
+}
+
+func matchOrder(item OrderItem, filter *order.ListFilter) bool {
+	if len(filter.Statuses) > 0 {
+		found := false
+		for _, status := range filter.Statuses {
+			found = found || item.Status == status
+		}
+		if !found {
+			return false
+		}
+	}
+	if !filter.UploadedFrom.IsZero() && item.Date.Time.Before(filter.UploadedFrom) {
+		return false
+	}
+	if !filter.UploadedTo.IsZero() && !item.Date.Time.Before(filter.UploadedTo) {
+		return false
+	}
+	return true
+}
+
+// cursorLess tells whether a goes before b in the sort order.
+func cursorLess(a order.Cursor, b order.Cursor, direction string) bool {
+	if direction == order.SortDesc {
+		a, b = b, a
+	}
+	if !a.Time.Equal(b.Time) {
+		return a.Time.Before(b.Time)
+	}
+	return a.ID < b.ID
+}
+
+func (ols *OrderLocalStorage) GetBalanceByUserID(ctx context.Context, userID int32) (*models.Balance, error) {
+	return ols.GetBalanceByUserIDAt(ctx, userID, time.Time{})
+}
+
+func (ols *OrderLocalStorage) GetBalanceByUserIDAt(ctx context.Context, userID int32, at time.Time) (*models.Balance, error) {
+	balance, err := ols.ledger.GetBalance(ctx, userID, at)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Balance{
+		Current:   models.Money(balance.Current),
+		Withdrawn: models.Money(balance.Withdrawn),
+	}, nil
+}
+
+func (ols *OrderLocalStorage) GetOrderByOrderUID(ctx context.Context, orderNumber string) (*models.Order, error) {
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
+	return ols.getOrder(orderNumber), nil
+}
+
+func (ols *OrderLocalStorage) GetOrderStatusHistory(ctx context.Context, orderNumber string) ([]*models.OrderStatusChange, error) {
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
+	result := make([]*models.OrderStatusChange, 0)
 	for _, item := range ols.order {
-		if item.UserID == userID {
-			resultItem := models.Order{
-				UserName: item.UserID,
-				Number:   item.Number,
-				Status:   item.Status,
-				Accrual:  float32(item.Accrual) / 100,
-				Uploaded: item.Date,
+		if item.Number == orderNumber && item.Debet {
+			for _, change := range item.History {
+				resultItem := *change
+				result = append(result, &resultItem)
 			}
-			result = append(result, resultItem)
 		}
 	}
 	return result, nil
 }
 
-func (ols *OrderLocalStorage) GetBalanceByUserID(ctx context.Context, userID int32) (*models.Balance, error) {
-	var result = new(models.Balance)
+func (ols *OrderLocalStorage) GetStatusLatency(ctx context.Context, since time.Time) (*order.LatencyReport, error) {
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
+	type transition struct {
+		from string
+		to   string
+	}
+	transitions := make(map[transition][]time.Duration)
+	processing := make([]time.Duration, 0)
 	for _, item := range ols.order {
-		if item.UserID == userID {
-			if item.Debet {
-				result.Current = result.Current + float32(item.Accrual)
-			} else {
-				result.Withdrawn = result.Withdrawn + float32(item.Accrual)
+		for i, change := range item.History {
+			if i == 0 || change.ChangedAt.Before(since) {
+				continue
+			}
+			key := transition{from: change.FromStatus, to: change.Status}
+			transitions[key] = append(transitions[key], change.ChangedAt.Sub(item.History[i-1].ChangedAt))
+			if order.IsFinalStatus(change.Status) {
+				processing = append(processing, change.ChangedAt.Sub(item.History[0].ChangedAt))
 			}
 		}
 	}
+
+	result := &order.LatencyReport{
+		Since:       since,
+		Transitions: make([]*order.TransitionLatency, 0, len(transitions)),
+		Processing:  order.NewLatency(processing),
+	}
+	for key, durations := range transitions {
+		result.Transitions = append(result.Transitions, &order.TransitionLatency{
+			FromStatus: key.from,
+			Status:     key.to,
+			Latency:    order.NewLatency(durations),
+		})
+	}
+	sort.Slice(result.Transitions, func(i, j int) bool {
+		if result.Transitions[i].FromStatus != result.Transitions[j].FromStatus {
+			return result.Transitions[i].FromStatus < result.Transitions[j].FromStatus
+		}
+		return result.Transitions[i].Status < result.Transitions[j].Status
+	})
 	return result, nil
 }
 
-func (ols *OrderLocalStorage) GetOrderByOrderUID(ctx context.Context, userID int32, orderNumber string) (*models.Order, error) {
+func (ols *OrderLocalStorage) getOrder(orderNumber string) *models.Order {
 	for _, item := range ols.order {
-		if item.Number == orderNumber && item.Debet && item.UserID == userID {
+		if item.Number == orderNumber && item.Debet {
 			return &models.Order{
 				UserName: item.UserID,
 				Number:   item.Number,
 				Status:   item.Status,
-				Accrual:  float32(item.Accrual) / 100,
+				Accrual:  item.Accrual,
+				Stuck:    item.Stuck,
 				Uploaded: item.Date,
-			}, nil
+			}
 		}
 	}
-	return nil, nil
+	return nil
 }
 
 func (ols *OrderLocalStorage) WithdrawBalance(ctx context.Context, userID int32, bw *models.BalanceWithdraw) error {
-	balance, err := ols.GetBalanceByUserID(ctx, userID)
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
+	for _, item := range ols.order {
+		if item.Number == bw.OrderID {
+			return order.ErrOrderBadNumber
+		}
+	}
+
+	balance, err := ols.ledger.GetBalance(ctx, userID, time.Time{})
 	if err != nil {
 		return err
 	}
-	if balance == nil {
-		return order.ErrNotEnougthBalance
-	}
 
-	currentBalance := balance.Current - balance.Withdrawn
-	if currentBalance < bw.Sum {
+	sum := int64(bw.Sum)
+	if balance.Current < sum {
 		return order.ErrNotEnougthBalance
 	}
 
-	item := OrderItem{
-		UserID:  userID,
-		Number:  bw.OrderID,
-		Debet:   false,
-		Status:  models.OrderStatusWithDrawn,
-		Accrual: int32(bw.Sum),
-		Date:    pgtype.Timestamp{},
+	err = ols.ledger.PostEntry(ctx, ledger.NewWithdrawalEntry(userID, bw.OrderID, sum))
+	if errors.Is(err, ledger.ErrEntryAlreadyPosted) {
+		return order.ErrOrderBadNumber
 	}
-
-	ols.order = append(ols.order, item)
-
-	return nil
+	return err
 }
 
-func (ols *OrderLocalStorage) Withdrawals(ctx context.Context, userID int32) ([]*models.Withdrawals, error) {
-	result := make([]*models.Withdrawals, 0)
-	for _, item := range ols.order {
-		if item.UserID == userID && !item.Debet {
-			resultItem := &models.Withdrawals{
-				OrderID:     item.Number,
-				Sum:         float32(item.Accrual) / 100,
-				ProcessedAt: item.Date.Time,
-			}
-			result = append(result, resultItem)
+func (ols *OrderLocalStorage) Withdrawals(ctx context.Context, userID int32, filter *order.WithdrawalsFilter) (*order.WithdrawalsPage, error) {
+	entryFilter := &ledger.EntryFilter{
+		Kind: ledger.EntryKindWithdrawal,
+		From: filter.From,
+		To:   filter.To,
+	}
+	if filter.Limit > 0 {
+		// one more entry tells whether there is a next page
+		entryFilter.Limit = filter.Limit + 1
+	}
+	if filter.After != nil {
+		entryFilter.AfterCreatedAt = filter.After.Time
+		entryFilter.AfterID = filter.After.ID
+	}
+	entries, err := ols.ledger.GetEntriesByUserID(ctx, userID, entryFilter)
+	if err != nil {
+		return nil, err
+	}
+	total, err := ols.ledger.GetEntriesTotal(ctx, userID, entryFilter, ledger.AccountKindWithdrawn)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &order.WithdrawalsPage{
+		Withdrawals: make([]*models.Withdrawals, 0, len(entries)),
+		Count:       total.Count,
+		Total:       models.Money(total.Amount),
+	}
+	for i, entry := range entries {
+		if filter.Limit > 0 && i == filter.Limit {
+			last := entries[i-1]
+			result.Next = &order.Cursor{Time: last.CreatedAt, ID: last.ID}
+			break
+		}
+		resultItem := &models.Withdrawals{
+			OrderID:     entry.Reference,
+			Sum:         models.Money(entry.Amount(userID, ledger.AccountKindWithdrawn)),
+			ProcessedAt: entry.CreatedAt,
 		}
+		result.Withdrawals = append(result.Withdrawals, resultItem)
 	}
 	return result, nil
 }
 
-func (ols *OrderLocalStorage) UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual int32) error {
+func (ols *OrderLocalStorage) UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual models.Money) error {
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
 	for id, item := range ols.order {
 		if item.Number == orderNumber && item.Debet {
+			if err := order.ValidateTransition(item.Status, orderStatus); err != nil {
+				return err
+			}
+			if item.Status != orderStatus {
+				ols.order[id].History = append(item.History, &models.OrderStatusChange{
+					FromStatus: item.Status,
+					Status:     orderStatus,
+					Accrual:    orderAccrual,
+					ChangedAt:  time.Now(),
+				})
+			}
 			ols.order[id].Status = orderStatus
 			ols.order[id].Accrual = orderAccrual
-			return nil
+
+			if orderStatus != models.OrderStatusProcessed || orderAccrual <= 0 {
+				return nil
+			}
+
+			err := ols.ledger.PostEntry(ctx, ledger.NewAccrualEntry(item.UserID, orderNumber, int64(orderAccrual)))
+			if errors.Is(err, ledger.ErrEntryAlreadyPosted) {
+				return nil
+			}
+			return err
 		}
 	}
 
 	return nil
 }
 
+func (ols *OrderLocalStorage) MarkOrderStuck(ctx context.Context, orderNumber string, reason string) error {
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
+	for id, item := range ols.order {
+		if item.Number == orderNumber && item.Debet {
+			ols.order[id].Stuck = true
+			return nil
+		}
+	}
+	return nil
+}
+
 func (ols *OrderLocalStorage) GetNotFinnalizedOrdersListByUserID(ctx context.Context, userID int32) ([]*models.Order, error) {
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
 	result := make([]*models.Order, 0)
 	for _, item := range ols.order {
-		if item.UserID == userID && (item.Status == models.OrderStatusNew || item.Status == models.OrderStatusProcessing) {
+		if item.UserID == userID && !item.Stuck && (item.Status == models.OrderStatusNew || item.Status == models.OrderStatusProcessing) {
 			resultItem := &models.Order{
 				UserName: item.UserID,
 				Number:   item.Number,
 				Status:   item.Status,
-				Accrual:  float32(item.Accrual) / 100,
+				Accrual:  item.Accrual,
 				Uploaded: item.Date,
 			}
 			result = append(result, resultItem)
@@ -170,14 +380,17 @@ func (ols *OrderLocalStorage) GetNotFinnalizedOrdersListByUserID(ctx context.Con
 }
 
 func (ols *OrderLocalStorage) GetNotFinnalizedOrdersList(ctx context.Context) ([]*models.Order, error) {
+	ols.mutex.Lock()
+	defer ols.mutex.Unlock()
+
 	result := make([]*models.Order, 0)
 	for _, item := range ols.order {
-		if item.Status == models.OrderStatusNew || item.Status == models.OrderStatusProcessing {
+		if !item.Stuck && (item.Status == models.OrderStatusNew || item.Status == models.OrderStatusProcessing) {
 			resultItem := &models.Order{
 				UserName: item.UserID,
 				Number:   item.Number,
 				Status:   item.Status,
-				Accrual:  float32(item.Accrual) / 100,
+				Accrual:  item.Accrual,
 				Uploaded: item.Date,
 			}
 			result = append(result, resultItem)
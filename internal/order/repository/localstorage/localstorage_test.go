@@ -0,0 +1,140 @@
+package localstorage
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/alexkopcak/gophermart/internal/order"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func numbers(page *order.OrdersPage) []string {
+	result := make([]string, 0, len(page.Orders))
+	for _, item := range page.Orders {
+		result = append(result, item.Number)
+	}
+	return result
+}
+
+func TestGetOrdersListByUserIDPages(t *testing.T) {
+	ctx := context.Background()
+	storage := NewOrderLocalStorage()
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, storage.InsertOrder(ctx, 1, strconv.Itoa(i)))
+	}
+	require.NoError(t, storage.InsertOrder(ctx, 2, "6"))
+	require.NoError(t, storage.UpdateOrder(ctx, "2", models.OrderStatusProcessed, 100))
+	require.NoError(t, storage.UpdateOrder(ctx, "4", models.OrderStatusInvalid, 0))
+
+	var pages [][]string
+	filter := &order.ListFilter{Sort: order.SortAsc, Limit: 2}
+	for {
+		page, err := storage.GetOrdersListByUserID(ctx, 1, filter)
+		require.NoError(t, err)
+		pages = append(pages, numbers(page))
+		if page.Next == nil {
+			break
+		}
+		filter.After, err = order.ParseCursor(page.Next.String())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, [][]string{{"1", "2"}, {"3", "4"}, {"5"}}, pages)
+
+	page, err := storage.GetOrdersListByUserID(ctx, 1, &order.ListFilter{Sort: order.SortDesc, Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5", "4", "3"}, numbers(page))
+	require.NotNil(t, page.Next)
+
+	page, err = storage.GetOrdersListByUserID(ctx, 1, &order.ListFilter{Sort: order.SortDesc, Limit: 3, After: page.Next})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "1"}, numbers(page))
+	assert.Nil(t, page.Next)
+
+	page, err = storage.GetOrdersListByUserID(ctx, 1, &order.ListFilter{Sort: order.SortAsc, Limit: 10,
+		Statuses: []string{models.OrderStatusProcessed, models.OrderStatusInvalid}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "4"}, numbers(page))
+
+	page, err = storage.GetOrdersListByUserID(ctx, 1, &order.ListFilter{Sort: order.SortAsc, Limit: 10,
+		UploadedTo: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Empty(t, page.Orders)
+}
+
+func TestInsertOrderConflict(t *testing.T) {
+	ctx := context.Background()
+	storage := NewOrderLocalStorage()
+
+	require.NoError(t, storage.InsertOrder(ctx, 1, "12345678903"))
+	assert.Equal(t, order.ErrOrderAlreadyInsertedByUser, storage.InsertOrder(ctx, 1, "12345678903"))
+	assert.Equal(t, order.ErrOrderAlreadyInsertedByOtherUser, storage.InsertOrder(ctx, 2, "12345678903"))
+
+	item, err := storage.GetOrderByOrderUID(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), item.UserName)
+}
+
+func TestGetStatusLatency(t *testing.T) {
+	ctx := context.Background()
+	storage := NewOrderLocalStorage()
+	start := time.Now()
+
+	require.NoError(t, storage.InsertOrder(ctx, 1, "12345678903"))
+	require.NoError(t, storage.InsertOrder(ctx, 1, "9278923470"))
+	require.NoError(t, storage.UpdateOrder(ctx, "12345678903", models.OrderStatusProcessing, 0))
+	require.NoError(t, storage.UpdateOrder(ctx, "12345678903", models.OrderStatusProcessed, 500))
+	require.NoError(t, storage.UpdateOrder(ctx, "9278923470", models.OrderStatusInvalid, 0))
+
+	report, err := storage.GetStatusLatency(ctx, start)
+	require.NoError(t, err)
+	require.Len(t, report.Transitions, 3)
+	assert.Equal(t, models.OrderStatusNew, report.Transitions[0].FromStatus)
+	assert.Equal(t, models.OrderStatusInvalid, report.Transitions[0].Status)
+	assert.Equal(t, models.OrderStatusNew, report.Transitions[1].FromStatus)
+	assert.Equal(t, models.OrderStatusProcessing, report.Transitions[1].Status)
+	assert.Equal(t, models.OrderStatusProcessing, report.Transitions[2].FromStatus)
+	assert.Equal(t, models.OrderStatusProcessed, report.Transitions[2].Status)
+	for _, transition := range report.Transitions {
+		assert.Equal(t, 1, transition.Count)
+	}
+	assert.Equal(t, 2, report.Processing.Count)
+	assert.LessOrEqual(t, report.Processing.P50, report.Processing.Max)
+
+	report, err = storage.GetStatusLatency(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, report.Transitions)
+	assert.Equal(t, 0, report.Processing.Count)
+}
+
+func TestUpdateOrderTransition(t *testing.T) {
+	ctx := context.Background()
+	storage := NewOrderLocalStorage()
+
+	require.NoError(t, storage.InsertOrder(ctx, 1, "12345678903"))
+	require.NoError(t, storage.UpdateOrder(ctx, "12345678903", models.OrderStatusProcessed, 500))
+
+	err := storage.UpdateOrder(ctx, "12345678903", models.OrderStatusProcessing, 0)
+	assert.ErrorIs(t, err, order.ErrIllegalTransition)
+
+	// a repeated final status does not overwrite the accrual
+	err = storage.UpdateOrder(ctx, "12345678903", models.OrderStatusProcessed, 700)
+	assert.ErrorIs(t, err, order.ErrIllegalTransition)
+
+	item, err := storage.GetOrderByOrderUID(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderStatusProcessed, item.Status)
+	assert.Equal(t, models.Money(500), item.Accrual)
+
+	history, err := storage.GetOrderStatusHistory(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	balance, err := storage.GetBalanceByUserID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(500), balance.Current)
+}
@@ -0,0 +1,105 @@
+package localstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/alexkopcak/gophermart/internal/order"
+)
+
+type queueItem struct {
+	job         models.AccrualJob
+	lockedUntil time.Time
+}
+
+type AccrualQueueLocalStorage struct {
+	jobs  map[string]*queueItem
+	mutex *sync.Mutex
+}
+
+func NewAccrualQueueLocalStorage() order.AccrualQueue {
+	return &AccrualQueueLocalStorage{
+		jobs:  make(map[string]*queueItem),
+		mutex: new(sync.Mutex),
+	}
+}
+
+func (aqs *AccrualQueueLocalStorage) Enqueue(ctx context.Context, orderNumber string) error {
+	aqs.mutex.Lock()
+	defer aqs.mutex.Unlock()
+
+	if _, exsist := aqs.jobs[orderNumber]; exsist {
+		return nil
+	}
+
+	now := time.Now()
+	aqs.jobs[orderNumber] = &queueItem{
+		job: models.AccrualJob{
+			OrderNumber: orderNumber,
+			NextRunAt:   now,
+			CreatedAt:   now,
+		},
+	}
+	return nil
+}
+
+func (aqs *AccrualQueueLocalStorage) Dequeue(ctx context.Context, lease time.Duration) (*models.AccrualJob, error) {
+	aqs.mutex.Lock()
+	defer aqs.mutex.Unlock()
+
+	now := time.Now()
+	var result *queueItem
+	for _, item := range aqs.jobs {
+		if item.job.NextRunAt.After(now) || item.lockedUntil.After(now) {
+			continue
+		}
+		if result == nil || item.job.NextRunAt.Before(result.job.NextRunAt) {
+			result = item
+		}
+	}
+	if result == nil {
+		return nil, nil
+	}
+
+	result.job.Attempts++
+	result.lockedUntil = now.Add(lease)
+	job := result.job
+	return &job, nil
+}
+
+func (aqs *AccrualQueueLocalStorage) Complete(ctx context.Context, orderNumber string) error {
+	aqs.mutex.Lock()
+	defer aqs.mutex.Unlock()
+
+	delete(aqs.jobs, orderNumber)
+	return nil
+}
+
+func (aqs *AccrualQueueLocalStorage) Retry(ctx context.Context, orderNumber string, delay time.Duration, reason string) error {
+	aqs.mutex.Lock()
+	defer aqs.mutex.Unlock()
+
+	if item, exsist := aqs.jobs[orderNumber]; exsist {
+		item.job.NextRunAt = time.Now().Add(delay)
+		item.job.LastError = reason
+		item.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (aqs *AccrualQueueLocalStorage) Postpone(ctx context.Context, orderNumber string, delay time.Duration, reason string) error {
+	aqs.mutex.Lock()
+	defer aqs.mutex.Unlock()
+
+	if item, exsist := aqs.jobs[orderNumber]; exsist {
+		if item.job.Attempts > 0 {
+			item.job.Attempts--
+		}
+		item.job.NextRunAt = time.Now().Add(delay)
+		item.job.LastError = reason
+		item.lockedUntil = time.Time{}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package localstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccrualQueue(t *testing.T) {
+	ctx := context.Background()
+	queue := NewAccrualQueueLocalStorage()
+
+	require.NoError(t, queue.Enqueue(ctx, "12345678903"))
+	require.NoError(t, queue.Enqueue(ctx, "12345678903"))
+
+	job, err := queue.Dequeue(ctx, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, "12345678903", job.OrderNumber)
+	assert.Equal(t, 1, job.Attempts)
+
+	// leased job is not handed out twice
+	job, err = queue.Dequeue(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, job)
+
+	require.NoError(t, queue.Retry(ctx, "12345678903", 0, "accrual system responded with 500"))
+	job, err = queue.Dequeue(ctx, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, 2, job.Attempts)
+	assert.Equal(t, "accrual system responded with 500", job.LastError)
+
+	// postponed job gets the attempt back
+	require.NoError(t, queue.Postpone(ctx, "12345678903", 0, "order is not registered in the accrual system"))
+	job, err = queue.Dequeue(ctx, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, 2, job.Attempts)
+
+	require.NoError(t, queue.Complete(ctx, "12345678903"))
+	require.NoError(t, queue.Retry(ctx, "12345678903", 0, ""))
+	job, err = queue.Dequeue(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, job)
+}
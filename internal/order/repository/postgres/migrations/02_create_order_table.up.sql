@@ -1,8 +0,0 @@
-CREATE TABLE orders (
-    user_id VARCHAR(255),
-    order_id VARCHAR(255) UNIQUE,
-    debet BOOLEAN,
-    order_status VARCHAR(255),
-    accrual INTEGER,
-    uploaded_at TIMESTAMP DEFAULT NOW()
-);
\ No newline at end of file
@@ -2,25 +2,30 @@ package postgres
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/alexkopcak/gophermart/internal/ledger"
+	ledgerdb "github.com/alexkopcak/gophermart/internal/ledger/repository/postgres"
 	"github.com/alexkopcak/gophermart/internal/models"
 	"github.com/alexkopcak/gophermart/internal/order"
 	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog/log"
 )
 
 type OrderPostgresStorage struct {
-	db *pgx.Conn
+	db     *pgxpool.Pool
+	ledger *ledgerdb.LedgerPostgresStorage
 }
 
-func NewOrderPostgresStorage(dbURI string) order.OrderRepository {
-	conn, err := pgx.Connect(context.Background(), dbURI)
-	if err != nil {
-		log.Fatal().Err(err)
-	}
+func NewOrderPostgresStorage(pool *pgxpool.Pool) order.OrderRepository {
 	return &OrderPostgresStorage{
-		db: conn,
+		db:     pool,
+		ledger: ledgerdb.NewLedgerPostgresStorage(pool),
 	}
 }
 
@@ -31,15 +36,15 @@ func (ops *OrderPostgresStorage) GetOrderByOrderUID(ctx context.Context, orderNu
 	defer logger.Debug().Msg("exit")
 
 	var result = new(models.Order)
-	var accrual int32
+	var accrual int64
 	var timeValue pgtype.Timestamp
 
 	logger.Debug().Str("orderNumber", orderNumber).Msg("try to get order")
 	err := ops.db.QueryRow(ctx,
-		"SELECT user_id, order_id, order_status, accrual, uploaded_at "+
+		"SELECT user_id, order_id, order_status, accrual, stuck_at IS NOT NULL, uploaded_at "+
 			"FROM orders "+
 			"WHERE order_id = $1 ", orderNumber).
-		Scan(&result.UserName, &result.Number, &result.Status, &accrual, &timeValue)
+		Scan(&result.UserName, &result.Number, &result.Status, &accrual, &result.Stuck, &timeValue)
 
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
@@ -49,13 +54,13 @@ func (ops *OrderPostgresStorage) GetOrderByOrderUID(ctx context.Context, orderNu
 		return nil, err
 	}
 
-	result.Accrual = float32(accrual) / 100
+	result.Accrual = models.Money(accrual)
 	result.Uploaded = timeValue
 
 	logger.Debug().Int32("order.user", result.UserName).
 		Str("order.id", result.Number).
 		Str("order.status", result.Status).
-		Float32("order.accrual", result.Accrual).
+		Stringer("order.accrual", result.Accrual).
 		Time("order.time", result.Uploaded.Time).
 		Msg("GetOrderByUID result")
 
@@ -70,10 +75,23 @@ func (ops *OrderPostgresStorage) InsertOrder(ctx context.Context, userID int32,
 
 	logger.Debug().Int32("userID", userID).Str("orderNumber", orderNumber).Msg("try to add new order")
 
+	// the history row and the accrual job are only added for an inserted order,
+	// in the same statement, so an order is never left out of the queue;
+	// a deleted user may still hold a valid access token, so the user row
+	// is checked and locked against a concurrent deletion
 	cTag, err := ops.db.Exec(ctx,
-		"INSERT INTO orders "+
+		"WITH inserted AS ("+
+			"INSERT INTO orders "+
 			"(user_id, order_id, debet, order_status, accrual) "+
-			"VALUES ($1, $2, TRUE, $3, $4) "+
+			"SELECT $1, $2, TRUE, $3, $4 "+
+			"WHERE EXISTS (SELECT 1 FROM users WHERE (id = $1) AND (deleted_at IS NULL) FOR SHARE) "+
+			"ON CONFLICT (order_id) DO NOTHING "+
+			"RETURNING order_id, order_status), "+
+			"history AS ("+
+			"INSERT INTO order_status_history (order_id, status) "+
+			"SELECT order_id, order_status FROM inserted) "+
+			"INSERT INTO accrual_jobs (order_id) "+
+			"SELECT order_id FROM inserted "+
 			"ON CONFLICT (order_id) DO NOTHING",
 		userID, orderNumber, models.OrderStatusNew, 0)
 
@@ -94,24 +112,57 @@ func (ops *OrderPostgresStorage) InsertOrder(ctx context.Context, userID int32,
 		if err != nil {
 			return err
 		}
+		logger.Debug().Int32("userID", userID).Msg("user is deleted")
+		return order.ErrUserNotAuthtorised
 	}
 	return err
 }
 
-func (ops *OrderPostgresStorage) GetOrdersListByUserID(ctx context.Context, userID int32) ([]models.Order, error) {
+func (ops *OrderPostgresStorage) GetOrdersListByUserID(ctx context.Context, userID int32, filter *order.ListFilter) (*order.OrdersPage, error) {
 	logger := log.With().Str("package", "postgres").Str("func", "GetOrdersListByUserID").Logger()
 
 	logger.Debug().Msg("enter")
 	defer logger.Debug().Msg("exit")
 
-	result := make([]models.Order, 0)
+	args := []interface{}{userID}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	conditions := []string{"(debet IS TRUE)", "(user_id = $1)"}
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, "(order_status = ANY("+arg(filter.Statuses)+"))")
+	}
+	// uploaded_at has no time zone and keeps the UTC time
+	if !filter.UploadedFrom.IsZero() {
+		conditions = append(conditions, "(uploaded_at >= "+arg(filter.UploadedFrom.UTC())+")")
+	}
+	if !filter.UploadedTo.IsZero() {
+		conditions = append(conditions, "(uploaded_at < "+arg(filter.UploadedTo.UTC())+")")
+	}
 
-	logger.Debug().Int32("userID", userID).Msg("try to get order list by user id")
+	direction, comparison := "ASC", ">"
+	if filter.Sort == order.SortDesc {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.After != nil {
+		conditions = append(conditions, "((uploaded_at, id) "+comparison+" ("+
+			arg(filter.After.Time.UTC())+", "+arg(filter.After.ID)+"))")
+	}
+
+	// one more row tells whether there is a next page
+	var limit string
+	if filter.Limit > 0 {
+		limit = " LIMIT " + arg(filter.Limit+1)
+	}
+
+	logger.Debug().Int32("userID", userID).Int("limit", filter.Limit).Str("sort", filter.Sort).Msg("try to get order list by user id")
 	rows, err := ops.db.Query(ctx,
-		"SELECT user_id, order_id, order_status, accrual, uploaded_at "+
+		"SELECT id, user_id, order_id, order_status, accrual, stuck_at IS NOT NULL, uploaded_at "+
 			"FROM orders "+
-			"WHERE (debet IS TRUE) AND (user_id = $1) "+
-			"ORDER BY uploaded_at ASC", userID)
+			"WHERE "+strings.Join(conditions, " AND ")+" "+
+			"ORDER BY uploaded_at "+direction+", id "+direction+limit, args...)
 
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
@@ -119,64 +170,63 @@ func (ops *OrderPostgresStorage) GetOrdersListByUserID(ctx context.Context, user
 	}
 	defer rows.Close()
 
+	result := &order.OrdersPage{Orders: make([]models.Order, 0)}
+	var last order.Cursor
 	for rows.Next() {
+		if filter.Limit > 0 && len(result.Orders) == filter.Limit {
+			result.Next = &last
+			break
+		}
+
+		var id int64
 		var item models.Order
 		var timeValue pgtype.Timestamp
-		var accrual int32
-		err := rows.Scan(&item.UserName, &item.Number, &item.Status, &accrual, &timeValue)
-		item.Accrual = float32(accrual) / 100
-		item.Uploaded = timeValue
+		var accrual int64
+		err := rows.Scan(&id, &item.UserName, &item.Number, &item.Status, &accrual, &item.Stuck, &timeValue)
 		if err != nil {
 			logger.Debug().Err(err).Msg("exit with error")
 			return nil, err
 		}
+		item.Accrual = models.Money(accrual)
+		item.Uploaded = timeValue
 		logger.Debug().Int32("order.user", item.UserName).
 			Str("order.id", item.Number).
 			Str("order.status", item.Status).
-			Float32("order.accrual", item.Accrual).
+			Stringer("order.accrual", item.Accrual).
 			Time("order.time", item.Uploaded.Time).
 			Msg("getOrderListByUID result item")
 
-		result = append(result, item)
+		last = order.Cursor{Time: timeValue.Time, ID: id}
+		result.Orders = append(result.Orders, item)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
 	}
 
 	return result, nil
 }
 
 func (ops *OrderPostgresStorage) GetBalanceByUserID(ctx context.Context, userID int32) (*models.Balance, error) {
-	logger := log.With().Str("package", "postgres").Str("func", "GetBalanceByUserID").Logger()
+	return ops.GetBalanceByUserIDAt(ctx, userID, time.Time{})
+}
+
+func (ops *OrderPostgresStorage) GetBalanceByUserIDAt(ctx context.Context, userID int32, at time.Time) (*models.Balance, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "GetBalanceByUserIDAt").Logger()
 
 	logger.Debug().Msg("enter")
 	defer logger.Debug().Msg("exit")
 
-	logger.Debug().Int32("userID", userID).Msg("try to get balance by userID")
-	var accrual int32
-	err := ops.db.QueryRow(ctx,
-		"SELECT COALESCE(SUM(accrual), 0) "+
-			"FROM orders "+
-			"WHERE (user_id = $1);", userID).Scan(&accrual)
-
+	logger.Debug().Int32("userID", userID).Time("at", at).Msg("try to get balance by userID")
+	balance, err := ops.ledger.GetBalance(ctx, userID, at)
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
 		return nil, err
 	}
 
-	var withdrawn int32
-	err = ops.db.QueryRow(ctx,
-		"SELECT COALESCE(SUM(accrual), 0) "+
-			"FROM orders "+
-			"WHERE (debet IS FALSE) AND (user_id = $1);", userID).Scan(&withdrawn)
-
-	if err != nil {
-		logger.Debug().Err(err).Msg("exit with error")
-		return nil, err
-	}
-
-	withdrawn = -1 * withdrawn
-
 	return &models.Balance{
-		Current:   float32(accrual) / 100,
-		Withdrawn: float32(withdrawn) / 100,
+		Current:   models.Money(balance.Current),
+		Withdrawn: models.Money(balance.Withdrawn),
 	}, nil
 }
 
@@ -186,58 +236,190 @@ func (ops *OrderPostgresStorage) WithdrawBalance(ctx context.Context, userID int
 	logger.Debug().Msg("enter")
 	defer logger.Debug().Msg("exit")
 
-	logger.Debug().Int32("userID", userID).Str("orderNumber", bw.OrderID).Float32("sum", bw.Sum).Msg("try to withdraw balance")
-	orderItem, err := ops.GetOrderByOrderUID(ctx, bw.OrderID)
-	if orderItem != nil {
-		logger.Debug().Msg("Bad order number")
-		return order.ErrOrderBadNumber
-	}
+	logger.Debug().Int32("userID", userID).Str("orderNumber", bw.OrderID).Stringer("sum", bw.Sum).Msg("try to withdraw balance")
+	sum := int64(bw.Sum)
+
+	err := ops.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		ledgerTx := ops.ledger.WithTx(tx)
+
+		// a deleted user may still hold a valid access token
+		var active bool
+		err := tx.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM users WHERE (id = $1) AND (deleted_at IS NULL) FOR SHARE)", userID).Scan(&active)
+		if err != nil {
+			return err
+		}
+		if !active {
+			logger.Debug().Msg("user is deleted")
+			return order.ErrUserNotAuthtorised
+		}
+
+		err = ledgerTx.LockAccount(ctx, ledger.Account{UserID: userID, Kind: ledger.AccountKindPoints})
+		if err != nil {
+			return err
+		}
+
+		var exsist bool
+		err = tx.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM orders WHERE order_id = $1)", bw.OrderID).Scan(&exsist)
+		if err != nil {
+			return err
+		}
+		if exsist {
+			logger.Debug().Msg("Bad order number")
+			return order.ErrOrderBadNumber
+		}
+
+		balance, err := ledgerTx.GetBalance(ctx, userID, time.Time{})
+		if err != nil {
+			return err
+		}
+
+		if balance.Current < sum {
+			logger.Debug().Int64("current", balance.Current).Msg("not enougth balance")
+			return order.ErrNotEnougthBalance
+		}
+
+		err = ledgerTx.PostEntry(ctx, ledger.NewWithdrawalEntry(userID, bw.OrderID, sum))
+		if errors.Is(err, ledger.ErrEntryAlreadyPosted) {
+			logger.Debug().Msg("Bad order number")
+			return order.ErrOrderBadNumber
+		}
+		return err
+	})
+
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
-		return nil
 	}
+	return err
+}
 
-	var balance int32
+func (ops *OrderPostgresStorage) Withdrawals(ctx context.Context, userID int32, filter *order.WithdrawalsFilter) (*order.WithdrawalsPage, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "Withdrawals").Logger()
 
-	err = ops.db.QueryRow(ctx,
-		"SELECT COALESCE(SUM(accrual), 0) "+
-			"FROM orders "+
-			"WHERE user_id = $1 ;", userID).Scan(&balance)
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
 
+	entryFilter := &ledger.EntryFilter{
+		Kind: ledger.EntryKindWithdrawal,
+		From: filter.From,
+		To:   filter.To,
+	}
+	if filter.Limit > 0 {
+		// one more entry tells whether there is a next page
+		entryFilter.Limit = filter.Limit + 1
+	}
+	if filter.After != nil {
+		entryFilter.AfterCreatedAt = filter.After.Time
+		entryFilter.AfterID = filter.After.ID
+	}
+	entries, err := ops.ledger.GetEntriesByUserID(ctx, userID, entryFilter)
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
-		return nil
+		return nil, err
+	}
+	total, err := ops.ledger.GetEntriesTotal(ctx, userID, entryFilter, ledger.AccountKindWithdrawn)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
 	}
 
-	if float32(balance)/100 < bw.Sum {
-		logger.Debug().Msg("not enougth balance")
-		return order.ErrNotEnougthBalance
+	result := &order.WithdrawalsPage{
+		Withdrawals: make([]*models.Withdrawals, 0, len(entries)),
+		Count:       total.Count,
+		Total:       models.Money(total.Amount),
+	}
+	for i, entry := range entries {
+		if filter.Limit > 0 && i == filter.Limit {
+			last := entries[i-1]
+			result.Next = &order.Cursor{Time: last.CreatedAt, ID: last.ID}
+			break
+		}
+		result.Withdrawals = append(result.Withdrawals, &models.Withdrawals{
+			OrderID:     entry.Reference,
+			Sum:         models.Money(entry.Amount(userID, ledger.AccountKindWithdrawn)),
+			ProcessedAt: entry.CreatedAt,
+		})
 	}
 
-	_, err = ops.db.Exec(ctx,
-		"INSERT INTO orders "+
-			"(user_id, order_id, debet, order_status, accrual) "+
-			"VALUES ($1, $2, FALSE, $3, $4);",
-		userID, bw.OrderID, models.OrderStatusWithDrawn, -100*bw.Sum)
+	return result, nil
+}
+
+func (ops *OrderPostgresStorage) UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual models.Money) error {
+	logger := log.With().Str("package", "postgres").Str("func", "UpdateOrder").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	logger.Debug().Str("orderStatus", orderStatus).Str("orderNumber", orderNumber).Stringer("orderAccurual", orderAccrual).Msg("before query")
+	err := ops.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var userID int32
+		var previousStatus string
+		if !order.IsKnownStatus(orderStatus) {
+			return &order.TransitionError{To: orderStatus}
+		}
+		// the order is updated only from the statuses it may leave for the new one
+		err := tx.QueryRow(ctx,
+			"WITH previous AS (SELECT order_status FROM orders WHERE order_id = $3 FOR UPDATE) "+
+				"UPDATE orders SET order_status = $1 , accrual = $2 FROM previous "+
+				"WHERE (order_id = $3) AND (previous.order_status = ANY($4)) "+
+				"RETURNING user_id, previous.order_status;",
+			orderStatus, int64(orderAccrual), orderNumber, order.TransitionSources(orderStatus)).Scan(&userID, &previousStatus)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = tx.QueryRow(ctx, "SELECT order_status FROM orders WHERE order_id = $1", orderNumber).Scan(&previousStatus)
+			if errors.Is(err, pgx.ErrNoRows) {
+				logger.Debug().Str("orderNumber", orderNumber).Msg("order not found")
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return &order.TransitionError{From: previousStatus, To: orderStatus}
+		}
+		if err != nil {
+			return err
+		}
 
-	logger.Debug().Err(err).Msg("exit with error")
+		if previousStatus != orderStatus {
+			_, err = tx.Exec(ctx,
+				"INSERT INTO order_status_history (order_id, from_status, status, accrual) VALUES ($1, $2, $3, $4)",
+				orderNumber, previousStatus, orderStatus, int64(orderAccrual))
+			if err != nil {
+				return err
+			}
+		}
+
+		if orderStatus != models.OrderStatusProcessed || orderAccrual <= 0 {
+			return nil
+		}
+
+		err = ops.ledger.WithTx(tx).PostEntry(ctx, ledger.NewAccrualEntry(userID, orderNumber, int64(orderAccrual)))
+		if errors.Is(err, ledger.ErrEntryAlreadyPosted) {
+			logger.Debug().Str("orderNumber", orderNumber).Msg("accrual already posted")
+			return nil
+		}
+		return err
+	})
+
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
 	return err
 }
 
-func (ops *OrderPostgresStorage) Withdrawals(ctx context.Context, userID int32) ([]*models.Withdrawals, error) {
-	logger := log.With().Str("package", "postgres").Str("func", "Withdrawals").Logger()
+func (ops *OrderPostgresStorage) GetOrderStatusHistory(ctx context.Context, orderNumber string) ([]*models.OrderStatusChange, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "GetOrderStatusHistory").Logger()
 
 	logger.Debug().Msg("enter")
 	defer logger.Debug().Msg("exit")
 
-	result := make([]*models.Withdrawals, 0)
+	result := make([]*models.OrderStatusChange, 0)
 
 	rows, err := ops.db.Query(ctx,
-		"SELECT order_id, accrual, uploaded_at "+
-			"FROM orders "+
-			"WHERE (debet IS FALSE) AND (user_id = $1) AND (order_status = $2) "+
-			"ORDER BY uploaded_at ASC;", userID, models.OrderStatusWithDrawn)
-
+		"SELECT from_status, status, accrual, changed_at "+
+			"FROM order_status_history "+
+			"WHERE order_id = $1 "+
+			"ORDER BY changed_at ASC, id ASC", orderNumber)
 	if err != nil {
 		logger.Debug().Err(err).Msg("exit with error")
 		return nil, err
@@ -245,34 +427,109 @@ func (ops *OrderPostgresStorage) Withdrawals(ctx context.Context, userID int32)
 	defer rows.Close()
 
 	for rows.Next() {
-		var item models.Withdrawals
-		var sum int32
-		var processedAt pgtype.Timestamp
-		err := rows.Scan(&item.OrderID, &sum, &processedAt)
-		if err != nil {
-			return nil, nil
+		var item models.OrderStatusChange
+		var accrual int64
+		if err = rows.Scan(&item.FromStatus, &item.Status, &accrual, &item.ChangedAt); err != nil {
+			logger.Debug().Err(err).Msg("exit with error")
+			return nil, err
 		}
-		item.Sum = float32(sum*-1) / 100
-		item.ProcessedAt = processedAt.Time
+		item.Accrual = models.Money(accrual)
 		result = append(result, &item)
 	}
 
-	return result, nil
+	return result, rows.Err()
 }
 
-func (ops *OrderPostgresStorage) UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual int32) error {
-	logger := log.With().Str("package", "postgres").Str("func", "UpdateOrder").Logger()
+func (ops *OrderPostgresStorage) GetStatusLatency(ctx context.Context, since time.Time) (*order.LatencyReport, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "GetStatusLatency").Logger()
 
 	logger.Debug().Msg("enter")
 	defer logger.Debug().Msg("exit")
 
-	logger.Debug().Str("orderStatus", orderStatus).Str("orderNumber", orderNumber).Int32("orderAccurual", orderAccrual).Msg("before query")
-	comTag, err := ops.db.Exec(context.Background(),
-		"UPDATE orders SET order_status = $1 , accrual = $2 WHERE order_id = $3 ;",
-		orderStatus, orderAccrual, orderNumber)
+	result := &order.LatencyReport{
+		Since:       since,
+		Transitions: make([]*order.TransitionLatency, 0),
+	}
+
+	// only the orders changed in the period are read, the previous change
+	// is looked up before the period is applied; the statuses backfilled
+	// by the migration have no real change time
+	rows, err := ops.db.Query(ctx,
+		"WITH transitions AS ("+
+			"SELECT from_status, status, changed_at, backfilled, "+
+			"EXTRACT(EPOCH FROM changed_at - LAG(changed_at) OVER (PARTITION BY order_id ORDER BY changed_at, id))::float8 AS latency "+
+			"FROM order_status_history "+
+			"WHERE order_id IN (SELECT order_id FROM order_status_history WHERE changed_at >= $1)) "+
+			"SELECT from_status, status, COUNT(*), AVG(latency), "+
+			"percentile_cont(0.5) WITHIN GROUP (ORDER BY latency), "+
+			"percentile_cont(0.95) WITHIN GROUP (ORDER BY latency), MAX(latency) "+
+			"FROM transitions "+
+			"WHERE (latency IS NOT NULL) AND (changed_at >= $1) AND (backfilled IS FALSE) "+
+			"GROUP BY from_status, status "+
+			"ORDER BY from_status, status", since)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item order.TransitionLatency
+		var avg, p50, p95, max float64
+		if err = rows.Scan(&item.FromStatus, &item.Status, &item.Count, &avg, &p50, &p95, &max); err != nil {
+			logger.Debug().Err(err).Msg("exit with error")
+			return nil, err
+		}
+		item.Avg, item.P50, item.P95, item.Max = seconds(avg), seconds(p50), seconds(p95), seconds(max)
+		result.Transitions = append(result.Transitions, &item)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
 
-	logger.Debug().Int64("Count", comTag.RowsAffected()).Msg("Rows affected")
-	logger.Debug().Err(err).Msg("exit with error")
+	var avg, p50, p95, max float64
+	err = ops.db.QueryRow(ctx,
+		"WITH processing AS ("+
+			"SELECT EXTRACT(EPOCH FROM h.changed_at - f.first_changed_at)::float8 AS latency "+
+			"FROM order_status_history h "+
+			"JOIN (SELECT order_id, MIN(changed_at) AS first_changed_at FROM order_status_history "+
+			"WHERE order_id IN (SELECT order_id FROM order_status_history WHERE changed_at >= $1) "+
+			"GROUP BY order_id) f "+
+			"ON f.order_id = h.order_id "+
+			"WHERE h.status IN ($2, $3) AND (h.changed_at >= $1) AND (h.backfilled IS FALSE)) "+
+			"SELECT COUNT(*), COALESCE(AVG(latency), 0), "+
+			"COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY latency), 0), "+
+			"COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY latency), 0), COALESCE(MAX(latency), 0) "+
+			"FROM processing", since, models.OrderStatusProcessed, models.OrderStatusInvalid).
+		Scan(&result.Processing.Count, &avg, &p50, &p95, &max)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+	result.Processing.Avg, result.Processing.P50 = seconds(avg), seconds(p50)
+	result.Processing.P95, result.Processing.Max = seconds(p95), seconds(max)
+
+	return result, nil
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+func (ops *OrderPostgresStorage) MarkOrderStuck(ctx context.Context, orderNumber string, reason string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "MarkOrderStuck").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	logger.Debug().Str("orderNumber", orderNumber).Str("reason", reason).Msg("mark order stuck")
+	_, err := ops.db.Exec(ctx,
+		"UPDATE orders SET stuck_at = NOW(), stuck_reason = $2 WHERE order_id = $1 ;",
+		orderNumber, reason)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
 	return err
 }
 
@@ -287,7 +544,7 @@ func (ops *OrderPostgresStorage) GetNotFinnalizedOrdersListByUserID(ctx context.
 	rows, err := ops.db.Query(ctx,
 		"SELECT user_id, order_id, order_status, accrual, uploaded_at "+
 			"FROM orders "+
-			"WHERE (debet IS TRUE) AND (user_id = $1) AND order_status NOT IN ($2, $3)"+
+			"WHERE (debet IS TRUE) AND (user_id = $1) AND (stuck_at IS NULL) AND order_status NOT IN ($2, $3) "+
 			"ORDER BY uploaded_at ASC;", userID, models.OrderStatusProcessed, models.OrderStatusInvalid)
 
 	if err != nil {
@@ -298,14 +555,14 @@ func (ops *OrderPostgresStorage) GetNotFinnalizedOrdersListByUserID(ctx context.
 
 	for rows.Next() {
 		var item models.Order
-		var accrual int32
+		var accrual int64
 		var uploaded pgtype.Timestamp
 		err := rows.Scan(&item.UserName, &item.Number, &item.Status, &accrual, &uploaded)
 		if err != nil {
 			logger.Debug().Err(err)
 			return nil, nil
 		}
-		item.Accrual = float32(accrual) / 100
+		item.Accrual = models.Money(accrual)
 		item.Uploaded = uploaded
 		result = append(result, &item)
 	}
@@ -324,7 +581,7 @@ func (ops *OrderPostgresStorage) GetNotFinnalizedOrdersList(ctx context.Context)
 	rows, err := ops.db.Query(ctx,
 		"SELECT user_id, order_id, order_status, accrual, uploaded_at "+
 			"FROM orders "+
-			"WHERE (debet IS TRUE) AND order_status NOT IN ($1, $2)"+
+			"WHERE (debet IS TRUE) AND (stuck_at IS NULL) AND order_status NOT IN ($1, $2) "+
 			"ORDER BY uploaded_at ASC;", models.OrderStatusProcessed, models.OrderStatusInvalid)
 
 	if err != nil {
@@ -335,14 +592,14 @@ func (ops *OrderPostgresStorage) GetNotFinnalizedOrdersList(ctx context.Context)
 
 	for rows.Next() {
 		var item models.Order
-		var accrual int32
+		var accrual int64
 		var uploaded pgtype.Timestamp
 		err := rows.Scan(&item.UserName, &item.Number, &item.Status, &accrual, &uploaded)
 		if err != nil {
 			logger.Debug().Err(err)
 			return nil, nil
 		}
-		item.Accrual = float32(accrual) / 100
+		item.Accrual = models.Money(accrual)
 		item.Uploaded = uploaded
 		result = append(result, &item)
 	}
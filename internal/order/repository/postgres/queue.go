@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/alexkopcak/gophermart/internal/order"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+type AccrualQueuePostgresStorage struct {
+	db *pgxpool.Pool
+}
+
+func NewAccrualQueuePostgresStorage(pool *pgxpool.Pool) order.AccrualQueue {
+	return &AccrualQueuePostgresStorage{
+		db: pool,
+	}
+}
+
+func (aqs *AccrualQueuePostgresStorage) Enqueue(ctx context.Context, orderNumber string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "Enqueue").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	logger.Debug().Str("orderNumber", orderNumber).Msg("try to enqueue order")
+	_, err := aqs.db.Exec(ctx,
+		"INSERT INTO accrual_jobs "+
+			"(order_id) "+
+			"VALUES ($1) "+
+			"ON CONFLICT (order_id) DO NOTHING", orderNumber)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
+
+func (aqs *AccrualQueuePostgresStorage) Dequeue(ctx context.Context, lease time.Duration) (*models.AccrualJob, error) {
+	logger := log.With().Str("package", "postgres").Str("func", "Dequeue").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	var job = new(models.AccrualJob)
+	err := aqs.db.QueryRow(ctx,
+		"UPDATE accrual_jobs "+
+			"SET attempts = attempts + 1, locked_until = NOW() + $1 * INTERVAL '1 millisecond' "+
+			"WHERE order_id = ("+
+			"SELECT order_id FROM accrual_jobs "+
+			"WHERE (next_run_at <= NOW()) AND (locked_until IS NULL OR locked_until < NOW()) "+
+			"ORDER BY next_run_at ASC "+
+			"FOR UPDATE SKIP LOCKED "+
+			"LIMIT 1) "+
+			"RETURNING order_id, attempts, next_run_at, created_at, last_error", lease.Milliseconds()).
+		Scan(&job.OrderNumber, &job.Attempts, &job.NextRunAt, &job.CreatedAt, &job.LastError)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+		return nil, err
+	}
+
+	logger.Debug().Str("orderNumber", job.OrderNumber).Int("attempts", job.Attempts).Msg("job leased")
+	return job, nil
+}
+
+func (aqs *AccrualQueuePostgresStorage) Complete(ctx context.Context, orderNumber string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "Complete").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	_, err := aqs.db.Exec(ctx,
+		"DELETE FROM accrual_jobs WHERE order_id = $1", orderNumber)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
+
+func (aqs *AccrualQueuePostgresStorage) Retry(ctx context.Context, orderNumber string, delay time.Duration, reason string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "Retry").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	logger.Debug().Str("orderNumber", orderNumber).Dur("delay", delay).Str("reason", reason).Msg("reschedule job")
+	_, err := aqs.db.Exec(ctx,
+		"UPDATE accrual_jobs "+
+			"SET next_run_at = NOW() + $2 * INTERVAL '1 millisecond', locked_until = NULL, last_error = $3 "+
+			"WHERE order_id = $1", orderNumber, delay.Milliseconds(), reason)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
+
+func (aqs *AccrualQueuePostgresStorage) Postpone(ctx context.Context, orderNumber string, delay time.Duration, reason string) error {
+	logger := log.With().Str("package", "postgres").Str("func", "Postpone").Logger()
+
+	logger.Debug().Msg("enter")
+	defer logger.Debug().Msg("exit")
+
+	logger.Debug().Str("orderNumber", orderNumber).Dur("delay", delay).Str("reason", reason).Msg("postpone job")
+	_, err := aqs.db.Exec(ctx,
+		"UPDATE accrual_jobs "+
+			"SET attempts = GREATEST(attempts - 1, 0), next_run_at = NOW() + $2 * INTERVAL '1 millisecond', "+
+			"locked_until = NULL, last_error = $3 "+
+			"WHERE order_id = $1", orderNumber, delay.Milliseconds(), reason)
+	if err != nil {
+		logger.Debug().Err(err).Msg("exit with error")
+	}
+	return err
+}
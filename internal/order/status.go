@@ -0,0 +1,70 @@
+package order
+
+import (
+	"fmt"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+)
+
+// transitions lists the statuses an order may move to. A repeated status is
+// allowed too, the accrual system reports it on every poll, except for
+// the final ones: the accrual of a finalized order is never overwritten.
+var transitions = map[string][]string{
+	models.OrderStatusNew:        {models.OrderStatusProcessing, models.OrderStatusInvalid, models.OrderStatusProcessed},
+	models.OrderStatusProcessing: {models.OrderStatusInvalid, models.OrderStatusProcessed},
+	models.OrderStatusInvalid:    {},
+	models.OrderStatusProcessed:  {},
+}
+
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: %s -> %s", ErrIllegalTransition, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrIllegalTransition
+}
+
+func IsKnownStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// IsFinalStatus tells whether the accrual system is done with the order.
+func IsFinalStatus(status string) bool {
+	return status == models.OrderStatusProcessed || status == models.OrderStatusInvalid
+}
+
+// ValidateTransition returns a TransitionError unless the order may move from
+// the status to the other one.
+func ValidateTransition(from string, to string) error {
+	if !IsKnownStatus(from) || !IsKnownStatus(to) {
+		return &TransitionError{From: from, To: to}
+	}
+	if from == to && !IsFinalStatus(from) {
+		return nil
+	}
+	for _, status := range transitions[from] {
+		if status == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
+
+// TransitionSources lists the statuses the order may move to the status from,
+// the repositories use them in the conditional updates.
+func TransitionSources(to string) []string {
+	result := make([]string, 0, len(transitions))
+	for _, from := range []string{models.OrderStatusNew, models.OrderStatusProcessing,
+		models.OrderStatusInvalid, models.OrderStatusProcessed} {
+		if ValidateTransition(from, to) == nil {
+			result = append(result, from)
+		}
+	}
+	return result
+}
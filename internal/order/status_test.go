@@ -0,0 +1,54 @@
+package order
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTransition(t *testing.T) {
+	tests := []struct {
+		from  string
+		to    string
+		legal bool
+	}{
+		{models.OrderStatusNew, models.OrderStatusProcessing, true},
+		{models.OrderStatusNew, models.OrderStatusProcessed, true},
+		{models.OrderStatusNew, models.OrderStatusInvalid, true},
+		{models.OrderStatusProcessing, models.OrderStatusProcessing, true},
+		{models.OrderStatusProcessing, models.OrderStatusProcessed, true},
+		{models.OrderStatusProcessing, models.OrderStatusInvalid, true},
+		{models.OrderStatusProcessing, models.OrderStatusNew, false},
+		{models.OrderStatusProcessed, models.OrderStatusProcessed, false},
+		{models.OrderStatusInvalid, models.OrderStatusInvalid, false},
+		{models.OrderStatusProcessed, models.OrderStatusProcessing, false},
+		{models.OrderStatusProcessed, models.OrderStatusInvalid, false},
+		{models.OrderStatusInvalid, models.OrderStatusProcessed, false},
+		{models.OrderStatusNew, models.OrderStatusWithDrawn, false},
+		{models.OrderStatusNew, "REGISTERED", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			err := ValidateTransition(tt.from, tt.to)
+			if tt.legal {
+				assert.NoError(t, err)
+				return
+			}
+			var transitionError *TransitionError
+			require.True(t, errors.As(err, &transitionError))
+			assert.Equal(t, tt.from, transitionError.From)
+			assert.Equal(t, tt.to, transitionError.To)
+			assert.ErrorIs(t, err, ErrIllegalTransition)
+		})
+	}
+}
+
+func TestTransitionSources(t *testing.T) {
+	assert.Equal(t, []string{models.OrderStatusNew, models.OrderStatusProcessing}, TransitionSources(models.OrderStatusProcessing))
+	assert.Equal(t, []string{models.OrderStatusNew, models.OrderStatusProcessing}, TransitionSources(models.OrderStatusProcessed))
+	assert.Equal(t, []string{models.OrderStatusNew, models.OrderStatusProcessing}, TransitionSources(models.OrderStatusInvalid))
+	assert.Equal(t, []string{models.OrderStatusNew}, TransitionSources(models.OrderStatusNew))
+}
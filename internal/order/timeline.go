@@ -0,0 +1,112 @@
+package order
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+)
+
+// TimelineStep is a transition of the order, Duration is the time the order
+// spent in FromStatus before it.
+type TimelineStep struct {
+	FromStatus string
+	Status     string
+	ChangedAt  time.Time
+	Duration   time.Duration
+}
+
+type Timeline struct {
+	Order *models.Order
+	Steps []TimelineStep
+	// ProcessingTime is counted from the upload to the final status, or
+	// until now while the order is processed.
+	ProcessingTime time.Duration
+	Finished       bool
+}
+
+// NewTimeline builds the timeline of the history in the order of the changes.
+func NewTimeline(item *models.Order, history []*models.OrderStatusChange, now time.Time) *Timeline {
+	result := &Timeline{
+		Order: item,
+		Steps: make([]TimelineStep, 0, len(history)),
+	}
+	for i, change := range history {
+		step := TimelineStep{
+			FromStatus: change.FromStatus,
+			Status:     change.Status,
+			ChangedAt:  change.ChangedAt,
+		}
+		if i > 0 {
+			step.Duration = change.ChangedAt.Sub(history[i-1].ChangedAt)
+		}
+		result.Steps = append(result.Steps, step)
+	}
+	if len(history) == 0 {
+		return result
+	}
+
+	last := history[len(history)-1]
+	result.Finished = IsFinalStatus(last.Status)
+	end := now
+	if result.Finished {
+		end = last.ChangedAt
+	}
+	result.ProcessingTime = end.Sub(history[0].ChangedAt)
+	return result
+}
+
+// Latency sums up durations, the percentiles are interpolated like
+// percentile_cont of PostgreSQL.
+type Latency struct {
+	Count int           `json:"count"`
+	Avg   time.Duration `json:"avg_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P95   time.Duration `json:"p95_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+func NewLatency(durations []time.Duration) Latency {
+	if len(durations) == 0 {
+		return Latency{}
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	return Latency{
+		Count: len(sorted),
+		Avg:   sum / time.Duration(len(sorted)),
+		P50:   percentile(sorted, 0.5),
+		P95:   percentile(sorted, 0.95),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+func percentile(sorted []time.Duration, fraction float64) time.Duration {
+	position := fraction * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	weight := position - float64(lower)
+	return sorted[lower] + time.Duration(math.Round(weight*float64(sorted[upper]-sorted[lower])))
+}
+
+// TransitionLatency is the time orders spent in FromStatus before they got Status.
+type TransitionLatency struct {
+	FromStatus string `json:"from_status"`
+	Status     string `json:"status"`
+	Latency
+}
+
+// LatencyReport covers the transitions made since the time, Processing is
+// the time from the upload to the final status.
+type LatencyReport struct {
+	Since       time.Time            `json:"since"`
+	Transitions []*TransitionLatency `json:"transitions"`
+	Processing  Latency              `json:"processing"`
+}
@@ -0,0 +1,52 @@
+package order
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexkopcak/gophermart/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTimeline(t *testing.T) {
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	history := []*models.OrderStatusChange{
+		{Status: models.OrderStatusNew, ChangedAt: start},
+		{FromStatus: models.OrderStatusNew, Status: models.OrderStatusProcessing, ChangedAt: start.Add(2 * time.Second)},
+	}
+
+	timeline := NewTimeline(&models.Order{}, history, start.Add(time.Minute))
+	require.Len(t, timeline.Steps, 2)
+	assert.Equal(t, time.Duration(0), timeline.Steps[0].Duration)
+	assert.Equal(t, 2*time.Second, timeline.Steps[1].Duration)
+	assert.False(t, timeline.Finished)
+	assert.Equal(t, time.Minute, timeline.ProcessingTime)
+
+	history = append(history, &models.OrderStatusChange{
+		FromStatus: models.OrderStatusProcessing,
+		Status:     models.OrderStatusProcessed,
+		Accrual:    500,
+		ChangedAt:  start.Add(10 * time.Second),
+	})
+	timeline = NewTimeline(&models.Order{}, history, start.Add(time.Minute))
+	require.Len(t, timeline.Steps, 3)
+	assert.Equal(t, 8*time.Second, timeline.Steps[2].Duration)
+	assert.True(t, timeline.Finished)
+	assert.Equal(t, 10*time.Second, timeline.ProcessingTime)
+
+	timeline = NewTimeline(&models.Order{}, nil, start)
+	assert.Empty(t, timeline.Steps)
+	assert.False(t, timeline.Finished)
+}
+
+func TestNewLatency(t *testing.T) {
+	assert.Equal(t, Latency{}, NewLatency(nil))
+
+	latency := NewLatency([]time.Duration{4 * time.Second, time.Second, 3 * time.Second, 2 * time.Second})
+	assert.Equal(t, 4, latency.Count)
+	assert.Equal(t, 2500*time.Millisecond, latency.Avg)
+	assert.Equal(t, 2500*time.Millisecond, latency.P50)
+	assert.Equal(t, 3850*time.Millisecond, latency.P95)
+	assert.Equal(t, 4*time.Second, latency.Max)
+}
@@ -2,6 +2,7 @@ package order
 
 import (
 	"context"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/models"
 )
@@ -10,11 +11,22 @@ const CtxUserKey = "user"
 
 type UseCase interface {
 	AddNewOrder(ctx context.Context, userID int32, orderNumber string) error
-	GetOrders(ctx context.Context, userID int32) ([]models.Order, error)
+	GetOrders(ctx context.Context, userID int32, filter *ListFilter) (*OrdersPage, error)
+	GetOrder(ctx context.Context, userID int32, orderNumber string) (*OrderDetails, error)
+	GetOrderTimeline(ctx context.Context, userID int32, orderNumber string) (*Timeline, error)
+	GetStatusLatency(ctx context.Context, since time.Time) (*LatencyReport, error)
 	GetBalance(ctx context.Context, userID int32) (*models.Balance, error)
+	GetBalanceAt(ctx context.Context, userID int32, at time.Time) (*models.Balance, error)
 	BalanceWithdraw(ctx context.Context, userID int32, bw *models.BalanceWithdraw) error
-	Withdrawals(ctx context.Context, userID int32) ([]*models.Withdrawals, error)
-	UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual int32) error
+	Withdrawals(ctx context.Context, userID int32, filter *WithdrawalsFilter) (*WithdrawalsPage, error)
+	UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual models.Money) error
+	MarkOrderStuck(ctx context.Context, orderNumber string, reason string) error
 	GetNotFinnalizedOrdersListByUserID(ctx context.Context, userID int32) ([]*models.Order, error)
 	GetNotFinnalizedOrdersList(ctx context.Context) ([]*models.Order, error)
+	EnqueueNotFinnalizedOrders(ctx context.Context) error
+}
+
+type OrderDetails struct {
+	Order   *models.Order
+	History []*models.OrderStatusChange
 }
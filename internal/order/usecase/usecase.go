@@ -3,6 +3,7 @@ package usecase
 import (
 	"context"
 	"strconv"
+	"time"
 
 	"github.com/alexkopcak/gophermart/internal/models"
 	"github.com/alexkopcak/gophermart/internal/order"
@@ -10,12 +11,14 @@ import (
 )
 
 type OrderUseCase struct {
-	orderRepo order.OrderRepository
+	orderRepo    order.OrderRepository
+	accrualQueue order.AccrualQueue
 }
 
-func NewOrderUseCase(orderRepo order.OrderRepository) order.UseCase {
+func NewOrderUseCase(orderRepo order.OrderRepository, accrualQueue order.AccrualQueue) order.UseCase {
 	return &OrderUseCase{
-		orderRepo: orderRepo,
+		orderRepo:    orderRepo,
+		accrualQueue: accrualQueue,
 	}
 }
 
@@ -36,34 +39,90 @@ func (ouc *OrderUseCase) AddNewOrder(ctx context.Context, userID int32, orderNum
 	if err != nil {
 		return err
 	}
-	return ouc.orderRepo.InsertOrder(ctx, userID, orderNumber)
+	err = ouc.orderRepo.InsertOrder(ctx, userID, orderNumber)
+	if err != nil {
+		return err
+	}
+	// the postgres repository enqueues the order with the insert already,
+	// enqueue does nothing for a queued order
+	return ouc.accrualQueue.Enqueue(ctx, orderNumber)
+}
+
+func (ouc *OrderUseCase) GetOrders(ctx context.Context, userID int32, filter *order.ListFilter) (*order.OrdersPage, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return ouc.orderRepo.GetOrdersListByUserID(ctx, userID, filter)
+}
+
+// GetOrder returns the order of the user with its status history.
+func (ouc *OrderUseCase) GetOrder(ctx context.Context, userID int32, orderNumber string) (*order.OrderDetails, error) {
+	item, err := ouc.orderRepo.GetOrderByOrderUID(ctx, orderNumber)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, order.ErrOrderNotFound
+	}
+	if item.UserName != userID {
+		return nil, order.ErrOrderOwnedByOtherUser
+	}
+
+	history, err := ouc.orderRepo.GetOrderStatusHistory(ctx, orderNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &order.OrderDetails{Order: item, History: history}, nil
+}
+
+func (ouc *OrderUseCase) GetOrderTimeline(ctx context.Context, userID int32, orderNumber string) (*order.Timeline, error) {
+	details, err := ouc.GetOrder(ctx, userID, orderNumber)
+	if err != nil {
+		return nil, err
+	}
+	return order.NewTimeline(details.Order, details.History, time.Now()), nil
 }
 
-func (ouc *OrderUseCase) GetOrders(ctx context.Context, userID int32) ([]models.Order, error) {
-	return ouc.orderRepo.GetOrdersListByUserID(ctx, userID)
+func (ouc *OrderUseCase) GetStatusLatency(ctx context.Context, since time.Time) (*order.LatencyReport, error) {
+	return ouc.orderRepo.GetStatusLatency(ctx, since)
 }
 
 func (ouc *OrderUseCase) GetBalance(ctx context.Context, useerID int32) (*models.Balance, error) {
 	return ouc.orderRepo.GetBalanceByUserID(ctx, useerID)
 }
 
+func (ouc *OrderUseCase) GetBalanceAt(ctx context.Context, userID int32, at time.Time) (*models.Balance, error) {
+	return ouc.orderRepo.GetBalanceByUserIDAt(ctx, userID, at)
+}
+
 func (ouc *OrderUseCase) BalanceWithdraw(ctx context.Context, userID int32, bw *models.BalanceWithdraw) error {
 	err := checkOrderID(bw.OrderID)
 	if err != nil {
 		return err
 	}
 
+	if bw.Sum <= 0 {
+		return order.ErrWithdrawBadSum
+	}
+
 	return ouc.orderRepo.WithdrawBalance(ctx, userID, bw)
 }
 
-func (ouc *OrderUseCase) Withdrawals(ctx context.Context, userID int32) ([]*models.Withdrawals, error) {
-	return ouc.orderRepo.Withdrawals(ctx, userID)
+func (ouc *OrderUseCase) Withdrawals(ctx context.Context, userID int32, filter *order.WithdrawalsFilter) (*order.WithdrawalsPage, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return ouc.orderRepo.Withdrawals(ctx, userID, filter)
 }
 
-func (ouc *OrderUseCase) UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual int32) error {
+func (ouc *OrderUseCase) UpdateOrder(ctx context.Context, orderNumber string, orderStatus string, orderAccrual models.Money) error {
 	return ouc.orderRepo.UpdateOrder(ctx, orderNumber, orderStatus, orderAccrual)
 }
 
+func (ouc *OrderUseCase) MarkOrderStuck(ctx context.Context, orderNumber string, reason string) error {
+	return ouc.orderRepo.MarkOrderStuck(ctx, orderNumber, reason)
+}
+
 func (ouc *OrderUseCase) GetNotFinnalizedOrdersListByUserID(ctx context.Context, userID int32) ([]*models.Order, error) {
 	return ouc.orderRepo.GetNotFinnalizedOrdersListByUserID(ctx, userID)
 }
@@ -71,3 +130,18 @@ func (ouc *OrderUseCase) GetNotFinnalizedOrdersListByUserID(ctx context.Context,
 func (ouc *OrderUseCase) GetNotFinnalizedOrdersList(ctx context.Context) ([]*models.Order, error) {
 	return ouc.orderRepo.GetNotFinnalizedOrdersList(ctx)
 }
+
+func (ouc *OrderUseCase) EnqueueNotFinnalizedOrders(ctx context.Context) error {
+	orders, err := ouc.orderRepo.GetNotFinnalizedOrdersList(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range orders {
+		err = ouc.accrualQueue.Enqueue(ctx, item.Number)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}